@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"go-service/internal/config"
+	"go-service/internal/loadbalancer"
+	"go-service/internal/metrics"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	_ "go.uber.org/automaxprocs"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	logHandler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level:     slog.LevelInfo,
+		AddSource: true,
+	})
+	slog.SetDefault(slog.New(logHandler))
+
+	cfg, err := config.LoadLoadBalancerConfig()
+	if err != nil {
+		slog.Error("Invalid load balancer configuration", "error", err)
+		os.Exit(1)
+	}
+
+	balancer, err := loadbalancer.NewBalancer(cfg)
+	if err != nil {
+		slog.Error("Failed to create load balancer", "error", err)
+		os.Exit(1)
+	}
+
+	server := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           balancer,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       30 * time.Second,
+	}
+
+	metricsServer := &http.Server{
+		Addr:    cfg.MetricsAddr,
+		Handler: metrics.Handler(metrics.Default),
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Load balancer failed to start", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Metrics server failed to start", "error", err)
+		}
+	}()
+
+	go func() {
+		balancer.RunHealthChecks(ctx)
+	}()
+
+	slog.Info("READY", "addr", cfg.Addr, "backends", cfg.Backends, "strategy", cfg.Strategy)
+	<-ctx.Done()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	server.Shutdown(shutdownCtx)
+	metricsServer.Shutdown(shutdownCtx)
+}
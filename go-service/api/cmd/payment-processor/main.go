@@ -2,14 +2,21 @@ package main
 
 import (
 	"context"
+	"go-service/internal/config"
+	"go-service/internal/dtos"
+	"go-service/internal/events"
 	"go-service/internal/gateway"
+	"go-service/internal/merchants"
 	"go-service/internal/queue"
 	"go-service/internal/server"
 	"go-service/internal/services"
+	"go-service/internal/wal"
+	"go-service/internal/webhooks"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
 	"syscall"
 	"time"
 
@@ -28,16 +35,23 @@ func main() {
 		cancel()
 	}()
 
-	appPort := "9999"
-
 	logHandler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level:     slog.LevelInfo,
 		AddSource: true,
 	})
 	slog.SetDefault(slog.New(logHandler))
 
+	workerPoolConfig, err := config.LoadWorkerPoolConfig()
+	if err != nil {
+		slog.Error("Invalid worker pool configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Every worker can sit in a blocking dequeue holding a connection, so the
+	// pool gets one per worker on top of go-redis' default size for the rest.
 	redisClient := redis.NewClient(&redis.Options{
 		Addr:         "redis:6379",
+		PoolSize:     workerPoolConfig.MaxWorkers + 10*runtime.GOMAXPROCS(0),
 		MinIdleConns: 20,
 		MaxRetries:   1,
 		DialTimeout:  2 * time.Second,
@@ -56,22 +70,97 @@ func main() {
 		}
 	}()
 
-	primaryPaymentProcessor := gateway.NewPaymentProcessor(os.Getenv("PAYMENT_PROCESSOR_URL_DEFAULT"))
-	secondaryPaymentProcessor := gateway.NewPaymentProcessor(os.Getenv("PAYMENT_PROCESSOR_URL_FALLBACK"))
-	processorManager := services.NewProcessorManager(redisClient, primaryPaymentProcessor, secondaryPaymentProcessor)
+	merchantsConfig, err := config.LoadMerchantsConfig()
+	if err != nil {
+		slog.Error("Failed to load merchants", "error", err)
+		os.Exit(1)
+	}
+
+	merchantRegistry, err := merchants.NewRegistry(merchantsConfig, redisClient)
+	if err != nil {
+		slog.Error("Invalid merchants configuration", "error", err)
+		os.Exit(1)
+	}
+
+	currencyRoutes, err := config.LoadCurrencyRoutes()
+	if err != nil {
+		slog.Error("Invalid currency routes", "error", err)
+		os.Exit(1)
+	}
+
+	primaryPaymentProcessor := gateway.NewLimitedProcessorFromConfig(
+		redisClient,
+		config.DefaultProcessor,
+		gateway.NewPaymentProcessor(os.Getenv("PAYMENT_PROCESSOR_URL_DEFAULT")),
+		config.LoadProcessorLimits(config.DefaultProcessor),
+	)
+	secondaryPaymentProcessor := gateway.NewLimitedProcessorFromConfig(
+		redisClient,
+		config.FallbackProcessor,
+		gateway.NewPaymentProcessor(os.Getenv("PAYMENT_PROCESSOR_URL_FALLBACK")),
+		config.LoadProcessorLimits(config.FallbackProcessor),
+	)
+	processorManager := services.NewProcessorManager(
+		redisClient,
+		primaryPaymentProcessor,
+		secondaryPaymentProcessor,
+		config.LoadHedgingConfig(),
+		merchantRegistry,
+		currencyRoutes,
+	)
 
-	q, err := queue.NewPaymentQueue(redisClient)
+	queueConfig, err := config.LoadQueueConfig()
+	if err != nil {
+		slog.Error("Invalid queue configuration", "error", err)
+		os.Exit(1)
+	}
+
+	q, err := queue.NewPaymentQueue(redisClient, queueConfig, merchantRegistry.Ids())
 	if err != nil {
 		slog.Error("Failed to create processor manager", "error", err)
 		os.Exit(1)
 	}
 
+	compactionConfig, err := config.LoadCompactionConfig()
+	if err != nil {
+		slog.Error("Invalid compaction configuration", "error", err)
+		os.Exit(1)
+	}
+
+	var walLog *wal.Log
+	if walConfig := config.LoadWALConfig(); walConfig.Dir != "" {
+		walLog, err = wal.Open(walConfig)
+		if err != nil {
+			slog.Error("Failed to open write-ahead log", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	webhookConfig, err := config.LoadWebhookConfig()
+	if err != nil {
+		slog.Error("Invalid webhook configuration", "error", err)
+		os.Exit(1)
+	}
+
+	notifier := webhooks.NewNotifier(webhookConfig, redisClient)
+	serverConfig, err := config.LoadServerConfig()
+	if err != nil {
+		slog.Error("Invalid server configuration", "error", err)
+		os.Exit(1)
+	}
+
+	hub := events.NewHub(serverConfig.Stream, redisClient)
+
 	paymentsService := services.NewPaymentService(
+		config.LoadPaymentConfig(),
 		processorManager,
 		q,
+		notifier,
+		hub,
+		walLog,
 	)
 
-	server := server.NewServer(appPort, paymentsService)
+	server := server.NewServer(paymentsService, serverConfig, merchantRegistry)
 
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -81,10 +170,45 @@ func main() {
 	}()
 
 	go func() {
-		paymentsService.StartWorker(ctx)
+		processorManager.StartHealthMonitor(ctx)
+	}()
+
+	go func() {
+		notifier.Run(ctx)
+	}()
+
+	go func() {
+		hub.Run(ctx)
+	}()
+
+	go func() {
+		paymentsService.StartWorker(ctx, workerPoolConfig)
 	}()
 
-	slog.Info("READY", "port", appPort)
+	if walLog != nil {
+		go func() {
+			walLog.Run(ctx, func(payments []*dtos.Payment) error {
+				return q.EnqueueBatch(ctx, payments)
+			})
+		}()
+	}
+
+	go func() {
+		services.NewCompactor(compactionConfig, q).Run(ctx)
+	}()
+
+	slog.Info("READY", "addr", serverConfig.Addr, "socket", serverConfig.Socket)
 	<-ctx.Done()
-	os.Exit(0)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to shut down server", "error", err)
+	}
+
+	if walLog != nil {
+		if err := walLog.Close(); err != nil {
+			slog.Error("Failed to close write-ahead log", "error", err)
+		}
+	}
 }
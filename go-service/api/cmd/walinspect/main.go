@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go-service/internal/config"
+	"go-service/internal/wal"
+	"os"
+)
+
+// walinspect summarises write-ahead log segments left on disk, given either
+// segment files or directories holding them. With -records it also prints
+// every intact payment as a JSON line.
+func main() {
+	records := flag.Bool("records", false, "print every payment as a JSON line")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-records] <dir|segment>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, arg := range flag.Args() {
+		paths, err := segmentPaths(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+			continue
+		}
+
+		for _, path := range paths {
+			if !inspect(path, *records) {
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func segmentPaths(arg string) ([]string, error) {
+	info, err := os.Stat(arg)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{arg}, nil
+	}
+	return wal.Segments(arg)
+}
+
+func inspect(path string, records bool) bool {
+	payments, err := wal.ReadSegment(path)
+	if err != nil && !errors.Is(err, wal.ErrTornRecord) {
+		fmt.Fprintln(os.Stderr, err)
+		return false
+	}
+
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+
+	first, last := "-", "-"
+	if len(payments) > 0 {
+		first = payments[0].RequestedAt.UTC().Format(config.DateTimeFormat)
+		last = payments[len(payments)-1].RequestedAt.UTC().Format(config.DateTimeFormat)
+	}
+	fmt.Printf("%s\tpayments=%d\tfirst=%s\tlast=%s\t%s\n", path, len(payments), first, last, status)
+
+	if records {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, payment := range payments {
+			encoder.Encode(payment)
+		}
+	}
+
+	return err == nil
+}
@@ -2,11 +2,13 @@ module go-service
 
 go 1.24.4
 
-require github.com/lib/pq v1.10.9
+require (
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/lib/pq v1.10.9
+	go.uber.org/automaxprocs v1.6.0
+)
 
 require (
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/go-redis/redis/v8 v8.11.5 // indirect
-	go.uber.org/automaxprocs v1.6.0 // indirect
 )
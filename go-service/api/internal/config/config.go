@@ -1,9 +1,579 @@
 package config
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 const (
 	HealthCheckCooldown = 5 * time.Second
 
+	DequeueTimeout   = 1 * time.Second
+	DequeueBatchSize = 10
+
 	DateTimeFormat = "2006-01-02T15:04:05.000Z"
+
+	DefaultProcessor  = "default"
+	FallbackProcessor = "fallback"
+
+	DefaultCurrency = "BRL"
 )
+
+type WorkerPoolConfig struct {
+	MinWorkers     int
+	MaxWorkers     int
+	ScaleInterval  time.Duration
+	DrainTarget    time.Duration
+	FailureRatio   float64
+	InitialLatency time.Duration
+}
+
+func LoadWorkerPoolConfig() (WorkerPoolConfig, error) {
+	cfg := WorkerPoolConfig{
+		MinWorkers:     GetEnvInt("WORKERS_MIN", 1),
+		MaxWorkers:     GetEnvInt("WORKERS_MAX", 16),
+		ScaleInterval:  GetEnvDuration("WORKERS_SCALE_INTERVAL", 500*time.Millisecond),
+		DrainTarget:    GetEnvDuration("WORKERS_DRAIN_TARGET", 1*time.Second),
+		FailureRatio:   GetEnvFloat("WORKERS_FAILURE_RATIO", 0.5),
+		InitialLatency: GetEnvDuration("WORKERS_INITIAL_LATENCY", 20*time.Millisecond),
+	}
+
+	if cfg.MinWorkers < 1 {
+		cfg.MinWorkers = 1
+	}
+	if cfg.MaxWorkers < cfg.MinWorkers {
+		cfg.MaxWorkers = cfg.MinWorkers
+	}
+
+	return cfg, positiveDuration("WORKERS_SCALE_INTERVAL", cfg.ScaleInterval)
+}
+
+// positiveDuration rejects durations that end up in time.NewTicker, which
+// panics on anything not above zero.
+func positiveDuration(key string, d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("%s must be positive, got %s", key, d)
+	}
+	return nil
+}
+
+type ProcessorLimits struct {
+	Rate        float64
+	Burst       int
+	MaxInFlight int
+	MaxWait     time.Duration
+	Shared      bool
+	Lease       time.Duration
+}
+
+func LoadProcessorLimits(name string) ProcessorLimits {
+	prefix := "PROCESSOR_" + strings.ToUpper(name) + "_"
+
+	limits := ProcessorLimits{
+		Rate:        GetEnvFloat(prefix+"RATE", 0),
+		Burst:       GetEnvInt(prefix+"BURST", 0),
+		MaxInFlight: GetEnvInt(prefix+"MAX_IN_FLIGHT", 0),
+		MaxWait:     GetEnvDuration(prefix+"MAX_WAIT", 50*time.Millisecond),
+		Shared:      GetEnvBool("PROCESSOR_LIMITS_SHARED", false),
+		Lease:       GetEnvDuration("PROCESSOR_LIMITS_LEASE", 5*time.Second),
+	}
+
+	if limits.Burst < 1 {
+		limits.Burst = max(1, int(limits.Rate))
+	}
+
+	return limits
+}
+
+type HedgingConfig struct {
+	Enabled    bool
+	Delay      time.Duration
+	MinDelay   time.Duration
+	Percentile float64
+}
+
+func LoadHedgingConfig() HedgingConfig {
+	return HedgingConfig{
+		Enabled:    GetEnvBool("HEDGING_ENABLED", false),
+		Delay:      GetEnvDuration("HEDGING_DELAY", 0),
+		MinDelay:   GetEnvDuration("HEDGING_MIN_DELAY", 20*time.Millisecond),
+		Percentile: GetEnvFloat("HEDGING_PERCENTILE", 0.95),
+	}
+}
+
+func ProcessorFee(name string) float64 {
+	switch name {
+	case DefaultProcessor:
+		return GetEnvFloat("PROCESSOR_DEFAULT_FEE", 0.05)
+	case FallbackProcessor:
+		return GetEnvFloat("PROCESSOR_FALLBACK_FEE", 0.15)
+	}
+	return 0
+}
+
+const (
+	QueueCodecBinary = "binary"
+	QueueCodecJSON   = "json"
+)
+
+// QueueConfig.Codec picks how new queue and processed records are written.
+// Both formats are always readable, so json is only needed while replicas
+// that predate the binary codec are still running.
+// QueueConfig.Lease is how long a dequeued payment stays claimed before
+// another worker may take it over.
+// QueueConfig.StatusTTL is how long the status of a processed or
+// dead-lettered payment stays readable; zero keeps it until purged.
+type QueueConfig struct {
+	Codec     string
+	Lease     time.Duration
+	StatusTTL time.Duration
+	Lanes     []LaneConfig
+}
+
+// A payment goes to the first lane whose Retries matches whether it is being
+// retried and whose MinAmount it reaches. Lanes are dequeued in proportion to
+// Weight whenever more than one has due payments.
+type LaneConfig struct {
+	Name      string  `json:"name"`
+	Weight    int     `json:"weight"`
+	MinAmount float64 `json:"minAmount,omitempty"`
+	Retries   bool    `json:"retries,omitempty"`
+}
+
+// FreshLane keeps the original queue key so payments queued before lanes
+// existed are still dequeued.
+const FreshLane = "fresh"
+
+func LoadQueueConfig() (QueueConfig, error) {
+	cfg := QueueConfig{
+		Codec:     GetEnv("QUEUE_CODEC", QueueCodecBinary),
+		Lease:     GetEnvDuration("QUEUE_LEASE", 30*time.Second),
+		StatusTTL: GetEnvDuration("PAYMENT_STATUS_TTL", 24*time.Hour),
+		Lanes: []LaneConfig{
+			{Name: FreshLane, Weight: 3},
+			{Name: "retry", Weight: 1, Retries: true},
+		},
+	}
+
+	if err := positiveDuration("QUEUE_LEASE", cfg.Lease); err != nil {
+		return cfg, err
+	}
+
+	if cfg.StatusTTL < 0 {
+		return cfg, errors.New("PAYMENT_STATUS_TTL must not be negative")
+	}
+
+	if cfg.Codec != QueueCodecBinary && cfg.Codec != QueueCodecJSON {
+		return cfg, fmt.Errorf("QUEUE_CODEC must be %s or %s", QueueCodecBinary, QueueCodecJSON)
+	}
+
+	if raw := GetEnv("QUEUE_LANES", ""); raw != "" {
+		cfg.Lanes = nil
+		if err := json.Unmarshal([]byte(raw), &cfg.Lanes); err != nil {
+			return cfg, fmt.Errorf("parsing QUEUE_LANES: %w", err)
+		}
+	}
+
+	if len(cfg.Lanes) == 0 {
+		return cfg, errors.New("QUEUE_LANES has no lanes")
+	}
+
+	seen := make(map[string]bool)
+	for _, lane := range cfg.Lanes {
+		if lane.Name == "" || seen[lane.Name] {
+			return cfg, fmt.Errorf("lane names must be unique and non-empty: %q", lane.Name)
+		}
+		if lane.Weight < 1 {
+			return cfg, fmt.Errorf("lane %s: weight must be at least 1", lane.Name)
+		}
+		seen[lane.Name] = true
+	}
+
+	return cfg, nil
+}
+
+type PaymentConfig struct {
+	MaxRetries      int
+	SummaryCacheTTL time.Duration
+	Retry           RetryConfig
+}
+
+func LoadPaymentConfig() PaymentConfig {
+	return PaymentConfig{
+		MaxRetries:      GetEnvInt("PAYMENT_MAX_RETRIES", 0),
+		SummaryCacheTTL: GetEnvDuration("SUMMARY_CACHE_TTL", 1*time.Second),
+		Retry:           LoadRetryConfig(),
+	}
+}
+
+// CompactionConfig.Retention is how long processed payments are kept as raw
+// entries; zero, the default, disables compaction and keeps them forever.
+// Older entries are rolled up into immutable aggregates of BucketSize, which
+// should divide a minute or an hour so summaries grouped by either still line
+// up. Summary windows reaching into rolled up data must start and end on
+// bucket boundaries. The correlationIds of rolled up payments are remembered
+// until they are DedupeRetention old, so a replay within that horizon is still
+// recognised as a duplicate; it must be longer than Retention.
+type CompactionConfig struct {
+	Retention       time.Duration
+	Interval        time.Duration
+	BucketSize      time.Duration
+	BatchSize       int
+	DedupeRetention time.Duration
+}
+
+func LoadCompactionConfig() (CompactionConfig, error) {
+	cfg := CompactionConfig{
+		Retention:       GetEnvDuration("PROCESSED_RETENTION", 0),
+		Interval:        GetEnvDuration("COMPACTION_INTERVAL", 1*time.Minute),
+		BucketSize:      GetEnvDuration("COMPACTION_BUCKET", 1*time.Minute),
+		BatchSize:       GetEnvInt("COMPACTION_BATCH_SIZE", 1000),
+		DedupeRetention: GetEnvDuration("COMPACTION_DEDUPE_RETENTION", 7*24*time.Hour),
+	}
+
+	if cfg.BucketSize <= 0 {
+		cfg.BucketSize = time.Minute
+	}
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 1000
+	}
+
+	if cfg.Retention < 0 {
+		return cfg, fmt.Errorf("PROCESSED_RETENTION must not be negative, got %s", cfg.Retention)
+	}
+	if cfg.Retention > 0 && cfg.DedupeRetention <= cfg.Retention {
+		return cfg, fmt.Errorf("COMPACTION_DEDUPE_RETENTION must be longer than PROCESSED_RETENTION, got %s and %s", cfg.DedupeRetention, cfg.Retention)
+	}
+	return cfg, positiveDuration("COMPACTION_INTERVAL", cfg.Interval)
+}
+
+// WALConfig.Dir enables the local write-ahead log that keeps accepted
+// payments when Redis rejects the write; empty disables it. Appends wait for
+// the fsync that follows them, which happens at most every SyncInterval.
+type WALConfig struct {
+	Dir             string
+	SyncInterval    time.Duration
+	SegmentBytes    int64
+	ReplayInterval  time.Duration
+	ReplayBatchSize int
+}
+
+func LoadWALConfig() WALConfig {
+	cfg := WALConfig{
+		Dir:             GetEnv("WAL_DIR", ""),
+		SyncInterval:    GetEnvDuration("WAL_SYNC_INTERVAL", 5*time.Millisecond),
+		SegmentBytes:    int64(GetEnvInt("WAL_SEGMENT_BYTES", 16<<20)),
+		ReplayInterval:  GetEnvDuration("WAL_REPLAY_INTERVAL", 1*time.Second),
+		ReplayBatchSize: GetEnvInt("WAL_REPLAY_BATCH_SIZE", 100),
+	}
+
+	if cfg.ReplayInterval <= 0 {
+		cfg.ReplayInterval = time.Second
+	}
+	if cfg.ReplayBatchSize < 1 {
+		cfg.ReplayBatchSize = 100
+	}
+
+	return cfg
+}
+
+const (
+	RetryExponential  = "exponential"
+	RetryDecorrelated = "decorrelated"
+	RetryFixed        = "fixed"
+)
+
+type RetryConfig struct {
+	Strategy         string
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	Schedule         []time.Duration
+	ConnRefusedDelay time.Duration
+}
+
+// LoadRetryConfig reads RETRY_SCHEDULE, used by the fixed strategy, as a
+// comma separated list of durations whose last entry repeats.
+func LoadRetryConfig() RetryConfig {
+	cfg := RetryConfig{
+		Strategy:         GetEnv("RETRY_STRATEGY", RetryExponential),
+		BaseDelay:        GetEnvDuration("RETRY_BASE_DELAY", 30*time.Millisecond),
+		MaxDelay:         GetEnvDuration("RETRY_MAX_DELAY", 5*time.Second),
+		ConnRefusedDelay: GetEnvDuration("RETRY_CONN_REFUSED_DELAY", 10*time.Millisecond),
+	}
+
+	switch cfg.Strategy {
+	case RetryExponential, RetryDecorrelated, RetryFixed:
+	default:
+		slog.Error("invalid retry strategy in environment, using default", "key", "RETRY_STRATEGY", "value", cfg.Strategy, "default", RetryExponential)
+		cfg.Strategy = RetryExponential
+	}
+
+	for _, part := range strings.Split(GetEnv("RETRY_SCHEDULE", "50ms,200ms,1s,5s"), ",") {
+		delay, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil {
+			slog.Error("invalid duration in RETRY_SCHEDULE, skipping it", "value", part)
+			continue
+		}
+		cfg.Schedule = append(cfg.Schedule, delay)
+	}
+	if len(cfg.Schedule) == 0 {
+		cfg.Schedule = []time.Duration{cfg.BaseDelay}
+	}
+
+	return cfg
+}
+
+type WebhookConfig struct {
+	Secret       string
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Timeout      time.Duration
+	BatchSize    int
+	PollInterval time.Duration
+	Lease        time.Duration
+}
+
+// LoadWebhookConfig leaves webhooks disabled when WEBHOOK_SECRET is empty, as
+// unsigned deliveries could be forged by anyone who knows a receiver's url.
+func LoadWebhookConfig() (WebhookConfig, error) {
+	cfg := WebhookConfig{
+		Secret:       GetEnv("WEBHOOK_SECRET", ""),
+		MaxAttempts:  GetEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		BaseDelay:    GetEnvDuration("WEBHOOK_BASE_DELAY", 500*time.Millisecond),
+		MaxDelay:     GetEnvDuration("WEBHOOK_MAX_DELAY", 5*time.Minute),
+		Timeout:      GetEnvDuration("WEBHOOK_TIMEOUT", 2*time.Second),
+		BatchSize:    GetEnvInt("WEBHOOK_BATCH_SIZE", 20),
+		PollInterval: GetEnvDuration("WEBHOOK_POLL_INTERVAL", 200*time.Millisecond),
+		Lease:        GetEnvDuration("WEBHOOK_LEASE", 30*time.Second),
+	}
+	return cfg, positiveDuration("WEBHOOK_POLL_INTERVAL", cfg.PollInterval)
+}
+
+type StreamConfig struct {
+	Buffer          int
+	SummaryInterval time.Duration
+	Heartbeat       time.Duration
+	// PublishInterval is how long processed payments are gathered before
+	// being sent to the other replicas as one message.
+	PublishInterval time.Duration
+}
+
+func LoadStreamConfig() (StreamConfig, error) {
+	cfg := StreamConfig{
+		Buffer:          GetEnvInt("STREAM_BUFFER", 256),
+		SummaryInterval: GetEnvDuration("STREAM_SUMMARY_INTERVAL", 1*time.Second),
+		Heartbeat:       GetEnvDuration("STREAM_HEARTBEAT", 15*time.Second),
+		PublishInterval: GetEnvDuration("STREAM_PUBLISH_INTERVAL", 50*time.Millisecond),
+	}
+	return cfg, errors.Join(
+		positiveDuration("STREAM_SUMMARY_INTERVAL", cfg.SummaryInterval),
+		positiveDuration("STREAM_HEARTBEAT", cfg.Heartbeat),
+		positiveDuration("STREAM_PUBLISH_INTERVAL", cfg.PublishInterval),
+	)
+}
+
+type ServerConfig struct {
+	Addr           string
+	Socket         string
+	SocketMode     os.FileMode
+	Stream         StreamConfig
+	BatchMaxBytes  int64
+	BatchChunkSize int
+	Currencies     []string
+	IntakeBuffer   int
+	IntakeWorkers  int
+	// AdminApiKey authorizes POST /purge-payments, which wipes every
+	// merchant's data.
+	AdminApiKey string
+}
+
+// LoadServerConfig listens on HTTP_ADDR, HTTP_SOCKET or both. With neither
+// set it keeps the historical :9999 TCP listener.
+func LoadServerConfig() (ServerConfig, error) {
+	stream, err := LoadStreamConfig()
+	if err != nil {
+		return ServerConfig{}, err
+	}
+
+	cfg := ServerConfig{
+		Addr:           GetEnv("HTTP_ADDR", ""),
+		Socket:         GetEnv("HTTP_SOCKET", ""),
+		SocketMode:     0o666,
+		Stream:         stream,
+		BatchMaxBytes:  batchMaxBytes(),
+		BatchChunkSize: GetEnvInt("PAYMENTS_BATCH_CHUNK_SIZE", 500),
+		Currencies:     LoadAllowedCurrencies(),
+		IntakeBuffer:   GetEnvInt("PAYMENTS_INTAKE_BUFFER", 4096),
+		IntakeWorkers:  GetEnvInt("PAYMENTS_INTAKE_WORKERS", 8),
+		AdminApiKey:    GetEnv("ADMIN_API_KEY", ""),
+	}
+
+	if cfg.Addr == "" && cfg.Socket == "" {
+		cfg.Addr = ":9999"
+	}
+
+	if cfg.BatchChunkSize <= 0 {
+		return ServerConfig{}, fmt.Errorf("PAYMENTS_BATCH_CHUNK_SIZE must be positive, got %d", cfg.BatchChunkSize)
+	}
+
+	if mode := GetEnv("HTTP_SOCKET_MODE", ""); mode != "" {
+		parsed, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			slog.Error("invalid octal mode in environment, using default", "key", "HTTP_SOCKET_MODE", "value", mode, "default", cfg.SocketMode)
+		} else {
+			cfg.SocketMode = os.FileMode(parsed)
+		}
+	}
+
+	return cfg, nil
+}
+
+// LoadAllowedCurrencies reads ALLOWED_CURRENCIES as a comma separated list
+// of ISO 4217 codes.
+func LoadAllowedCurrencies() []string {
+	var currencies []string
+	for _, code := range strings.Split(GetEnv("ALLOWED_CURRENCIES", "BRL,USD,EUR"), ",") {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			currencies = append(currencies, code)
+		}
+	}
+	return currencies
+}
+
+// LoadCurrencyRoutes reads CURRENCY_ROUTES as a JSON object mapping a
+// currency to the processors allowed to handle it, in order of preference,
+// e.g. {"USD":["fallback"]}. Currencies without an entry use any processor.
+func LoadCurrencyRoutes() (map[string][]string, error) {
+	routes := make(map[string][]string)
+
+	raw := GetEnv("CURRENCY_ROUTES", "")
+	if raw == "" {
+		return routes, nil
+	}
+
+	var parsed map[string][]string
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		return nil, fmt.Errorf("parsing CURRENCY_ROUTES: %w", err)
+	}
+
+	for currency, processors := range parsed {
+		for _, processor := range processors {
+			if processor != DefaultProcessor && processor != FallbackProcessor {
+				return nil, fmt.Errorf("currency %s: unknown processor %q", currency, processor)
+			}
+		}
+		routes[strings.ToUpper(currency)] = processors
+	}
+
+	return routes, nil
+}
+
+type MerchantConfig struct {
+	Id         string   `json:"id"`
+	ApiKey     string   `json:"apiKey"`
+	RateLimit  float64  `json:"rateLimit"`
+	Burst      int      `json:"burst"`
+	Processors []string `json:"processors"`
+}
+
+type MerchantsConfig struct {
+	Merchants    []MerchantConfig
+	SharedLimits bool
+}
+
+// LoadMerchantsConfig reads MERCHANTS as a JSON array of MerchantConfig.
+// An entry with id "default" configures the merchant used for requests
+// that carry no API key.
+func LoadMerchantsConfig() (MerchantsConfig, error) {
+	cfg := MerchantsConfig{
+		SharedLimits: GetEnvBool("MERCHANT_LIMITS_SHARED", false),
+	}
+
+	raw := GetEnv("MERCHANTS", "")
+	if raw == "" {
+		return cfg, nil
+	}
+
+	if err := json.Unmarshal([]byte(raw), &cfg.Merchants); err != nil {
+		return cfg, fmt.Errorf("parsing MERCHANTS: %w", err)
+	}
+
+	return cfg, nil
+}
+
+const (
+	BalanceLeastConn  = "least_conn"
+	BalanceRoundRobin = "round_robin"
+)
+
+type LoadBalancerConfig struct {
+	Addr            string
+	MetricsAddr     string
+	Backends        []string
+	Strategy        string
+	MaxIdleConns    int
+	IdleConnTimeout time.Duration
+	DialTimeout     time.Duration
+	ResponseTimeout time.Duration
+	MaxBodyBytes    int64
+	HealthPath      string
+	HealthInterval  time.Duration
+	MaxFails        int
+	FailTimeout     time.Duration
+}
+
+func batchMaxBytes() int64 {
+	return int64(GetEnvInt("PAYMENTS_BATCH_MAX_BYTES", 8<<20))
+}
+
+// LoadLoadBalancerConfig reads LB_BACKENDS as a comma separated list of
+// host:port, http:// URLs or unix:/path/to.sock entries. LB_MAX_BODY_BYTES
+// defaults to PAYMENTS_BATCH_MAX_BYTES and may not be set below it, or the
+// load balancer would refuse batches the API accepts; set both on the load
+// balancer when raising the batch limit.
+func LoadLoadBalancerConfig() (LoadBalancerConfig, error) {
+	cfg := LoadBalancerConfig{
+		Addr:            GetEnv("LB_ADDR", ":9999"),
+		MetricsAddr:     GetEnv("LB_METRICS_ADDR", ":9100"),
+		Strategy:        GetEnv("LB_STRATEGY", BalanceLeastConn),
+		MaxIdleConns:    GetEnvInt("LB_KEEPALIVE", 32),
+		IdleConnTimeout: GetEnvDuration("LB_KEEPALIVE_TIMEOUT", 60*time.Second),
+		DialTimeout:     GetEnvDuration("LB_DIAL_TIMEOUT", 3*time.Second),
+		ResponseTimeout: GetEnvDuration("LB_RESPONSE_TIMEOUT", 8*time.Second),
+		MaxBodyBytes:    int64(GetEnvInt("LB_MAX_BODY_BYTES", int(batchMaxBytes()))),
+		HealthPath:      GetEnv("LB_HEALTH_PATH", "/healthcheck"),
+		HealthInterval:  GetEnvDuration("LB_HEALTH_INTERVAL", 2*time.Second),
+		MaxFails:        GetEnvInt("LB_MAX_FAILS", 3),
+		FailTimeout:     GetEnvDuration("LB_FAIL_TIMEOUT", 30*time.Second),
+	}
+
+	if cfg.Strategy != BalanceLeastConn && cfg.Strategy != BalanceRoundRobin {
+		return cfg, fmt.Errorf("LB_STRATEGY must be %s or %s", BalanceLeastConn, BalanceRoundRobin)
+	}
+
+	if limit := batchMaxBytes(); cfg.MaxBodyBytes > 0 && cfg.MaxBodyBytes < limit {
+		return cfg, fmt.Errorf("LB_MAX_BODY_BYTES (%d) is below PAYMENTS_BATCH_MAX_BYTES (%d)", cfg.MaxBodyBytes, limit)
+	}
+
+	if err := positiveDuration("LB_HEALTH_INTERVAL", cfg.HealthInterval); err != nil {
+		return cfg, err
+	}
+
+	for _, backend := range strings.Split(GetEnv("LB_BACKENDS", "go-service-1:9999,go-service-2:9999"), ",") {
+		if backend = strings.TrimSpace(backend); backend != "" {
+			cfg.Backends = append(cfg.Backends, backend)
+		}
+	}
+	if len(cfg.Backends) == 0 {
+		return cfg, errors.New("LB_BACKENDS has no backends")
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+func GetEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func GetEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Error("invalid integer in environment, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func GetEnvFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		slog.Error("invalid number in environment, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func GetEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Error("invalid boolean in environment, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Error("invalid duration in environment, using default", "key", key, "value", value, "default", fallback)
+		return fallback
+	}
+	return parsed
+}
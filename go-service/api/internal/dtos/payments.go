@@ -5,24 +5,112 @@ import "time"
 type CreatePaymentRequest struct {
 	CorrelationId string  `json:"correlationId"`
 	Amount        float64 `json:"amount"`
+	CallbackUrl   string  `json:"callbackUrl,omitempty"`
+	Currency      string  `json:"currency,omitempty"`
+	MerchantId    string  `json:"-"`
 }
 
+// TimeField picks which timestamp From and To apply to: when the payment was
+// accepted by us or when it was last submitted to a processor.
+const (
+	TimeFieldRequested = "requestedAt"
+	TimeFieldSubmitted = "submittedAt"
+)
+
 type GetPaymentsSummaryFilters struct {
-	From time.Time
-	To   time.Time
+	MerchantId    string
+	From          time.Time
+	To            time.Time
+	FromExclusive bool
+	ToExclusive   bool
+	TimeField     string
 }
 
 type GetPaymentsSummaryFiltersJson struct {
-	From string `json:"from"`
-	To   string `json:"to"`
+	MerchantId    string `json:"merchantId,omitempty"`
+	From          string `json:"from"`
+	To            string `json:"to"`
+	FromExclusive bool   `json:"fromExclusive,omitempty"`
+	ToExclusive   bool   `json:"toExclusive,omitempty"`
+	TimeField     string `json:"timeField,omitempty"`
+}
+
+type SummaryOptions struct {
+	GroupBy    time.Duration
+	Fees       bool
+	Latency    bool
+	Currencies bool
 }
 
 type GetPaymentSummaryResponse struct {
+	Default  PaymentSummary  `json:"default"`
+	Fallback PaymentSummary  `json:"fallback"`
+	Buckets  []SummaryBucket `json:"buckets,omitempty"`
+}
+
+type SummaryBucket struct {
+	Start    string         `json:"start"`
 	Default  PaymentSummary `json:"default"`
 	Fallback PaymentSummary `json:"fallback"`
 }
 
+// PaymentSummary's top-level totals only count payments in the default
+// currency, BRL, so amounts are never mixed. ByCurrency breaks every currency
+// down, the default included. It is only present when asked for with
+// include=currencies or when the summary holds payments in another currency,
+// so single-currency responses keep their original shape.
 type PaymentSummary struct {
-	TotalRequests int64   `json:"totalRequests"`
-	TotalAmount   float64 `json:"totalAmount"`
+	TotalRequests int64                      `json:"totalRequests"`
+	TotalAmount   float64                    `json:"totalAmount"`
+	TotalFee      *float64                   `json:"totalFee,omitempty"`
+	NetAmount     *float64                   `json:"netAmount,omitempty"`
+	Latency       *LatencyPercentiles        `json:"latencyMs,omitempty"`
+	ByCurrency    map[string]CurrencySummary `json:"byCurrency,omitempty"`
+}
+
+type CurrencySummary struct {
+	TotalRequests int64    `json:"totalRequests"`
+	TotalAmount   float64  `json:"totalAmount"`
+	TotalFee      *float64 `json:"totalFee,omitempty"`
+	NetAmount     *float64 `json:"netAmount,omitempty"`
+}
+
+type LatencyPercentiles struct {
+	P50 int64 `json:"p50"`
+	P90 int64 `json:"p90"`
+	P95 int64 `json:"p95"`
+	P99 int64 `json:"p99"`
+	Max int64 `json:"max"`
+}
+
+type BatchRejection struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+type CreatePaymentsBatchResponse struct {
+	Accepted []int            `json:"accepted"`
+	Rejected []BatchRejection `json:"rejected"`
+}
+
+type RegisterWebhookRequest struct {
+	Url string `json:"url"`
+}
+
+type WebhooksResponse struct {
+	Urls []string `json:"urls"`
+}
+
+type PaymentStatusResponse struct {
+	CorrelationId string  `json:"correlationId"`
+	State         string  `json:"state"`
+	Amount        float64 `json:"amount"`
+	Currency      string  `json:"currency"`
+	RequestedAt   string  `json:"requestedAt"`
+	SubmittedAt   string  `json:"submittedAt,omitempty"`
+	RetryCount    int     `json:"retryCount,omitempty"`
+	NextAttemptAt string  `json:"nextAttemptAt,omitempty"`
+	Processor     string  `json:"processor,omitempty"`
+	ProcessedAt   string  `json:"processedAt,omitempty"`
+	Error         string  `json:"error,omitempty"`
 }
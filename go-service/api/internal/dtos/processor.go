@@ -8,9 +8,54 @@ type HealthCheckResponse struct {
 }
 
 type Payment struct {
-	CorrelationId string    `json:"correlationId"`
-	Amount        float64   `json:"amount"`
-	RequestedAt   time.Time `json:"requestedAt"`
-	Processor     string    `json:"processor,omitempty"`
-	RetryCount    int       `json:"retryCount,omitempty"`
+	CorrelationId string        `json:"correlationId"`
+	Amount        float64       `json:"amount"`
+	RequestedAt   time.Time     `json:"requestedAt"`
+	SubmittedAt   time.Time     `json:"submittedAt,omitzero"`
+	Processor     string        `json:"processor,omitempty"`
+	RetryCount    int           `json:"retryCount,omitempty"`
+	RetryDelay    time.Duration `json:"retryDelay,omitempty"`
+	CallbackUrl   string        `json:"callbackUrl,omitempty"`
+	ProcessedAt   time.Time     `json:"processedAt,omitzero"`
+	MerchantId    string        `json:"merchantId,omitempty"`
+	Currency      string        `json:"currency,omitempty"`
+}
+
+const (
+	PaymentStateQueued       = "queued"
+	PaymentStateInFlight     = "in_flight"
+	PaymentStateRetrying     = "retrying"
+	PaymentStateProcessed    = "processed"
+	PaymentStateDeadLettered = "dead_lettered"
+)
+
+// Rollup is an immutable aggregate of processed payments whose time falls in
+// the bucket of Size starting at Start. A bucket may have several rollups when
+// payments for it were acknowledged after it was first compacted.
+type Rollup struct {
+	Id     string        `json:"id"`
+	Start  time.Time     `json:"start"`
+	Size   time.Duration `json:"size,omitempty"`
+	Totals []RollupTotal `json:"totals"`
+}
+
+type RollupTotal struct {
+	Processor string  `json:"processor"`
+	Currency  string  `json:"currency"`
+	Count     int64   `json:"count"`
+	Amount    float64 `json:"amount"`
+}
+
+type PaymentStatus struct {
+	CorrelationId string
+	State         string
+	Amount        float64
+	Currency      string
+	RequestedAt   time.Time
+	SubmittedAt   time.Time
+	RetryCount    int
+	NextAttemptAt time.Time
+	Processor     string
+	ProcessedAt   time.Time
+	Error         string
 }
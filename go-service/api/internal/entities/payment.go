@@ -1,17 +1,59 @@
 package entities
 
+import "time"
+
 type Payment struct {
 	CorrelationId string
 	Amount        float64
 	RequestedAt   string
 }
 
+type PaymentStatus struct {
+	CorrelationId string
+	State         string
+	Amount        float64
+	Currency      string
+	RequestedAt   time.Time
+	SubmittedAt   time.Time
+	RetryCount    int
+	NextAttemptAt time.Time
+	Processor     string
+	ProcessedAt   time.Time
+	Error         string
+}
+
 type PaymentsSummary struct {
 	Default  PaymentStats
 	Fallback PaymentStats
+	Buckets  []SummaryBucket
+}
+
+type SummaryBucket struct {
+	Start    time.Time
+	Default  PaymentStats
+	Fallback PaymentStats
 }
 
 type PaymentStats struct {
 	TotalRequests int64
 	TotalAmount   float64
+	TotalFee      float64
+	NetAmount     float64
+	Latency       *LatencyPercentiles
+	ByCurrency    map[string]CurrencyStats
+}
+
+type CurrencyStats struct {
+	TotalRequests int64
+	TotalAmount   float64
+	TotalFee      float64
+	NetAmount     float64
+}
+
+type LatencyPercentiles struct {
+	P50 time.Duration
+	P90 time.Duration
+	P95 time.Duration
+	P99 time.Duration
+	Max time.Duration
 }
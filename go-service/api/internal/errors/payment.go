@@ -1,6 +1,25 @@
 package errors
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 var ErrNoPaymentProcessorAvailable = errors.New("no processor is available")
 var ErrNoPaymentsInQueue = errors.New("no payments in queue")
+var ErrPaymentNotFound = errors.New("payment not found")
+var ErrProcessorSaturated = errors.New("processor is over its rate or concurrency limit")
+var ErrDuplicatePayment = errors.New("processor already holds a payment with this correlationId")
+var ErrSummaryWindowMisaligned = errors.New("summary window starts or ends inside a compacted bucket")
+var ErrExportWindowCompacted = errors.New("export window reaches into compacted payments")
+
+type ProcessorError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *ProcessorError) Error() string {
+	return fmt.Sprintf("payment processor returned status %d: %s", e.StatusCode, e.Body)
+}
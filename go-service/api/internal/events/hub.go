@@ -0,0 +1,286 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"go-service/internal/config"
+	"go-service/internal/dtos"
+	"go-service/internal/merchants"
+	"go-service/internal/metrics"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	channel = "payments:events"
+
+	EventPaymentProcessed = "payment"
+	EventSummaryDelta     = "summary"
+)
+
+var (
+	subscribersGauge   = metrics.NewGauge("stream_subscribers", "Clients connected to the payments event stream.")
+	droppedSubscribers = metrics.NewCounter("stream_subscribers_dropped_total", "Stream clients dropped for falling behind.")
+)
+
+type ProcessedPayment struct {
+	MerchantId    string  `json:"-"`
+	CorrelationId string  `json:"correlationId"`
+	Amount        float64 `json:"amount"`
+	Currency      string  `json:"currency"`
+	Processor     string  `json:"processor"`
+	RequestedAt   string  `json:"requestedAt"`
+	SubmittedAt   string  `json:"submittedAt,omitempty"`
+	ProcessedAt   string  `json:"processedAt"`
+}
+
+type SummaryDelta struct {
+	From     string              `json:"from"`
+	To       string              `json:"to"`
+	Default  dtos.PaymentSummary `json:"default"`
+	Fallback dtos.PaymentSummary `json:"fallback"`
+}
+
+type Message struct {
+	Event string
+	Data  []byte
+}
+
+type Subscription struct {
+	C       <-chan Message
+	Dropped <-chan struct{}
+
+	merchantId string
+	messages   chan Message
+	dropped    chan struct{}
+}
+
+type Hub struct {
+	cfg config.StreamConfig
+	rc  *redis.Client
+
+	mu          sync.Mutex
+	pending     []envelope
+	subscribers map[*Subscription]struct{}
+	listeners   []func(ProcessedPayment)
+	deltas      map[string]*SummaryDelta
+	deltaStart  time.Time
+}
+
+// envelope carries the merchant next to the public event payload so replicas
+// can route events to the right subscribers without exposing it to clients.
+type envelope struct {
+	MerchantId string           `json:"merchantId"`
+	Payment    ProcessedPayment `json:"payment"`
+}
+
+func NewHub(cfg config.StreamConfig, rc *redis.Client) *Hub {
+	return &Hub{
+		cfg:         cfg,
+		rc:          rc,
+		subscribers: make(map[*Subscription]struct{}),
+		deltas:      make(map[string]*SummaryDelta),
+		deltaStart:  time.Now(),
+	}
+}
+
+// Publish only queues the payments. Run sends everything queued since its
+// last tick as a single message, so workers never wait on Redis for events.
+func (h *Hub) Publish(payments ...ProcessedPayment) {
+	h.mu.Lock()
+	for _, p := range payments {
+		h.pending = append(h.pending, envelope{MerchantId: merchantOrDefault(p.MerchantId), Payment: p})
+	}
+	h.mu.Unlock()
+}
+
+func (h *Hub) flushPending(ctx context.Context) error {
+	h.mu.Lock()
+	pending := h.pending
+	h.pending = nil
+	h.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	return h.rc.Publish(ctx, channel, payload).Err()
+}
+
+func (h *Hub) Subscribe(merchantId string) *Subscription {
+	sub := &Subscription{
+		merchantId: merchantOrDefault(merchantId),
+		messages:   make(chan Message, h.cfg.Buffer),
+		dropped:    make(chan struct{}),
+	}
+	sub.C = sub.messages
+	sub.Dropped = sub.dropped
+
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	subscribersGauge.Set(float64(len(h.subscribers)))
+	h.mu.Unlock()
+
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	delete(h.subscribers, sub)
+	subscribersGauge.Set(float64(len(h.subscribers)))
+	h.mu.Unlock()
+}
+
+// OnProcessed registers fn to be called for every processed payment event,
+// including those published by other replicas.
+func (h *Hub) OnProcessed(fn func(ProcessedPayment)) {
+	h.mu.Lock()
+	h.listeners = append(h.listeners, fn)
+	h.mu.Unlock()
+}
+
+func (h *Hub) Run(ctx context.Context) {
+	pubsub := h.rc.Subscribe(ctx, channel)
+	defer pubsub.Close()
+
+	ticker := time.NewTicker(h.cfg.SummaryInterval)
+	defer ticker.Stop()
+
+	publish := time.NewTicker(h.cfg.PublishInterval)
+	defer publish.Stop()
+
+	incoming := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-incoming:
+			if !ok {
+				return
+			}
+			h.handle([]byte(msg.Payload))
+		case <-publish.C:
+			if err := h.flushPending(ctx); err != nil && ctx.Err() == nil {
+				slog.Error("failed to publish processed payments", "error", err)
+			}
+		case now := <-ticker.C:
+			h.flushDelta(now)
+		}
+	}
+}
+
+func (h *Hub) handle(payload []byte) {
+	var batch []envelope
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		slog.Error("dropping malformed payment event", "error", err)
+		return
+	}
+
+	for _, env := range batch {
+		h.handleEnvelope(env)
+	}
+}
+
+func (h *Hub) handleEnvelope(env envelope) {
+	p := env.Payment
+	p.MerchantId = env.MerchantId
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		slog.Error("failed to encode payment event", "error", err)
+		return
+	}
+
+	h.mu.Lock()
+	delta, ok := h.deltas[p.MerchantId]
+	if !ok {
+		delta = &SummaryDelta{}
+		h.deltas[p.MerchantId] = delta
+	}
+
+	summary := &delta.Default
+	if p.Processor == config.FallbackProcessor {
+		summary = &delta.Fallback
+	}
+	// Deltas mirror /payments-summary, whose totals only cover the default currency.
+	if p.Currency == "" || p.Currency == config.DefaultCurrency {
+		summary.TotalRequests++
+		summary.TotalAmount += p.Amount
+	}
+	listeners := h.listeners
+	h.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(p)
+	}
+
+	h.broadcast(p.MerchantId, Message{Event: EventPaymentProcessed, Data: data})
+}
+
+func (h *Hub) flushDelta(now time.Time) {
+	h.mu.Lock()
+	deltas := h.deltas
+	from := h.deltaStart.UTC().Format(config.DateTimeFormat)
+	to := now.UTC().Format(config.DateTimeFormat)
+	h.deltas = make(map[string]*SummaryDelta)
+	h.deltaStart = now
+
+	merchantIds := make(map[string]struct{})
+	for sub := range h.subscribers {
+		merchantIds[sub.merchantId] = struct{}{}
+	}
+	h.mu.Unlock()
+
+	for merchantId := range merchantIds {
+		delta := SummaryDelta{}
+		if d, ok := deltas[merchantId]; ok {
+			delta = *d
+		}
+		delta.From = from
+		delta.To = to
+
+		payload, err := json.Marshal(delta)
+		if err != nil {
+			slog.Error("failed to encode summary delta", "error", err)
+			continue
+		}
+
+		h.broadcast(merchantId, Message{Event: EventSummaryDelta, Data: payload})
+	}
+}
+
+// broadcast never blocks: a subscriber whose buffer is full is dropped and
+// told so through its Dropped channel.
+func (h *Hub) broadcast(merchantId string, msg Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscribers {
+		if sub.merchantId != merchantId {
+			continue
+		}
+
+		select {
+		case sub.messages <- msg:
+		default:
+			delete(h.subscribers, sub)
+			close(sub.dropped)
+			droppedSubscribers.Inc()
+		}
+	}
+	subscribersGauge.Set(float64(len(h.subscribers)))
+}
+
+func merchantOrDefault(merchantId string) string {
+	if merchantId == "" {
+		return merchants.DefaultMerchantId
+	}
+	return merchantId
+}
@@ -2,13 +2,17 @@ package gateway
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"go-service/internal/config"
 	"go-service/internal/dtos"
+	internalErrors "go-service/internal/errors"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -71,14 +75,16 @@ type PaymentProcessorResponse struct {
 	Message string `json:"message"`
 }
 
-func (pp *Processor) Process(payment dtos.Payment) error {
-
+func (pp *Processor) Process(ctx context.Context, payment dtos.Payment) error {
 	url := fmt.Sprintf("%s/payments", pp.url)
 
 	request := PaymentProcessorRequest{
 		CorrelationId: payment.CorrelationId,
 		Amount:        payment.Amount,
-		RequestedAt:   payment.RequestedAt.UTC().Format(config.DateTimeFormat),
+		RequestedAt:   payment.SubmittedAt.UTC().Format(config.DateTimeFormat),
+	}
+	if payment.SubmittedAt.IsZero() {
+		request.RequestedAt = payment.RequestedAt.UTC().Format(config.DateTimeFormat)
 	}
 
 	jsonData, err := json.Marshal(request)
@@ -86,7 +92,13 @@ func (pp *Processor) Process(payment dtos.Payment) error {
 		return fmt.Errorf("failed to marshal payment request: %w", err)
 	}
 
-	resp, err := httpClient.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("creating payment request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send payment request: %w", err)
 	}
@@ -94,7 +106,19 @@ func (pp *Processor) Process(payment dtos.Payment) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("payment processor returned status %d: %s", resp.StatusCode, string(body))
+
+		// The processors reject a correlationId they have already accepted with a
+		// 422, but they also use 422 for requests they find invalid, so only a
+		// reply that names the correlationId counts as a duplicate.
+		if resp.StatusCode == http.StatusUnprocessableEntity && duplicateRejection(body) {
+			return internalErrors.ErrDuplicatePayment
+		}
+
+		return &internalErrors.ProcessorError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+			Body:       string(body),
+		}
 	}
 
 	var response PaymentProcessorResponse
@@ -105,6 +129,33 @@ func (pp *Processor) Process(payment dtos.Payment) error {
 	return nil
 }
 
+func duplicateRejection(body []byte) bool {
+	var response PaymentProcessorResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return false
+	}
+
+	message := strings.ToLower(response.Message)
+	return strings.Contains(message, "correlationid") &&
+		(strings.Contains(message, "already") || strings.Contains(message, "duplicate"))
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+
+	return 0
+}
+
 func (pp *Processor) Clear() error {
 	url := fmt.Sprintf("%s/admin/purge-payments", pp.url)
 
@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"go-service/internal/dtos"
+	internalErrors "go-service/internal/errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProcessClassifiesUnprocessableReplies(t *testing.T) {
+	cases := []struct {
+		body      string
+		duplicate bool
+	}{
+		{`{"message":"CorrelationId already exists"}`, true},
+		{`{"message":"duplicate correlationId"}`, true},
+		{`{"message":"amount must be positive"}`, false},
+		{`{"message":"correlationId must be a UUID"}`, false},
+		{`not json`, false},
+		{``, false},
+	}
+
+	for _, c := range cases {
+		processor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(c.body))
+		}))
+
+		err := NewPaymentProcessor(processor.URL).Process(context.Background(), dtos.Payment{
+			CorrelationId: "c",
+			Amount:        1,
+			RequestedAt:   time.Now(),
+		})
+		processor.Close()
+
+		if c.duplicate {
+			if !errors.Is(err, internalErrors.ErrDuplicatePayment) {
+				t.Errorf("reply %q: got %v, want ErrDuplicatePayment", c.body, err)
+			}
+			continue
+		}
+
+		var processorErr *internalErrors.ProcessorError
+		if !errors.As(err, &processorErr) || processorErr.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("reply %q: got %v, want a 422 ProcessorError", c.body, err)
+		}
+	}
+}
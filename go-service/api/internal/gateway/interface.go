@@ -1,11 +1,12 @@
 package gateway
 
 import (
+	"context"
 	"go-service/internal/dtos"
 )
 
 type PaymentProcessorInterface interface {
 	Healthcheck() (failing bool, minResponseTime int, err error)
-	Process(payment dtos.Payment) error
+	Process(ctx context.Context, payment dtos.Payment) error
 	Clear() error
 }
@@ -0,0 +1,135 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"go-service/internal/config"
+	"go-service/internal/dtos"
+	internalErrors "go-service/internal/errors"
+	"go-service/internal/ratelimit"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const defaultRateLimitCooldown = 1 * time.Second
+
+type LimitedProcessor struct {
+	PaymentProcessorInterface
+	limiter   ratelimit.Limiter
+	semaphore ratelimit.Semaphore
+	maxWait   time.Duration
+
+	mu            sync.RWMutex
+	cooldownUntil time.Time
+}
+
+func NewLimitedProcessor(
+	processor PaymentProcessorInterface,
+	limiter ratelimit.Limiter,
+	semaphore ratelimit.Semaphore,
+	maxWait time.Duration,
+) *LimitedProcessor {
+	return &LimitedProcessor{
+		PaymentProcessorInterface: processor,
+		limiter:                   limiter,
+		semaphore:                 semaphore,
+		maxWait:                   maxWait,
+	}
+}
+
+func NewLimitedProcessorFromConfig(rc *redis.Client, name string, processor PaymentProcessorInterface, limits config.ProcessorLimits) *LimitedProcessor {
+	var limiter ratelimit.Limiter
+	var semaphore ratelimit.Semaphore
+
+	if limits.Rate > 0 {
+		if limits.Shared {
+			limiter = ratelimit.NewRedisTokenBucket(rc, "processors:ratelimit:"+name, limits.Rate, limits.Burst)
+		} else {
+			limiter = ratelimit.NewTokenBucket(limits.Rate, limits.Burst)
+		}
+	}
+
+	if limits.MaxInFlight > 0 {
+		if limits.Shared {
+			semaphore = ratelimit.NewRedisSemaphore(rc, "processors:inflight:"+name, limits.MaxInFlight, limits.Lease)
+		} else {
+			semaphore = ratelimit.NewLocalSemaphore(limits.MaxInFlight)
+		}
+	}
+
+	return NewLimitedProcessor(processor, limiter, semaphore, limits.MaxWait)
+}
+
+func (lp *LimitedProcessor) Process(ctx context.Context, payment dtos.Payment) error {
+	release, err := lp.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = lp.PaymentProcessorInterface.Process(ctx, payment)
+
+	var processorErr *internalErrors.ProcessorError
+	if errors.As(err, &processorErr) && processorErr.StatusCode == http.StatusTooManyRequests {
+		cooldown := processorErr.RetryAfter
+		if cooldown <= 0 {
+			cooldown = defaultRateLimitCooldown
+		}
+
+		lp.mu.Lock()
+		lp.cooldownUntil = time.Now().Add(cooldown)
+		lp.mu.Unlock()
+	}
+
+	return err
+}
+
+func (lp *LimitedProcessor) acquire(ctx context.Context) (func(), error) {
+	lp.mu.RLock()
+	cooldown := time.Until(lp.cooldownUntil)
+	lp.mu.RUnlock()
+
+	if cooldown > lp.maxWait {
+		return nil, internalErrors.ErrProcessorSaturated
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, lp.maxWait)
+	defer cancel()
+
+	if cooldown > 0 {
+		select {
+		case <-time.After(cooldown):
+		case <-waitCtx.Done():
+			return nil, saturated(ctx, waitCtx.Err())
+		}
+	}
+
+	if lp.limiter != nil {
+		if err := lp.limiter.Wait(waitCtx); err != nil {
+			return nil, saturated(ctx, err)
+		}
+	}
+
+	if lp.semaphore == nil {
+		return func() {}, nil
+	}
+
+	release, err := lp.semaphore.Acquire(waitCtx)
+	if err != nil {
+		return nil, saturated(ctx, err)
+	}
+	return release, nil
+}
+
+func saturated(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return internalErrors.ErrProcessorSaturated
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+package loadbalancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-service/internal/config"
+	"go-service/internal/metrics"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const unixPrefix = "unix:"
+
+type Backend struct {
+	name      string
+	target    *url.URL
+	transport *http.Transport
+	proxy     *httputil.ReverseProxy
+
+	active       atomic.Int64
+	fails        atomic.Int64
+	ejectedUntil atomic.Int64
+
+	maxFails    int64
+	failTimeout time.Duration
+
+	requests    *metrics.Counter
+	errors      *metrics.Counter
+	ejections   *metrics.Counter
+	activeGauge *metrics.Gauge
+	up          *metrics.Gauge
+}
+
+// NewBackend accepts host:port, an http:// URL or unix:/path/to.sock. Unix
+// backends are dialled through the socket; the URL host is only a label.
+func NewBackend(address string, cfg config.LoadBalancerConfig) (*Backend, error) {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConns,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		ResponseHeaderTimeout: cfg.ResponseTimeout,
+	}
+
+	var target *url.URL
+	switch {
+	case strings.HasPrefix(address, unixPrefix):
+		path := strings.TrimPrefix(address, unixPrefix)
+		if path == "" {
+			return nil, fmt.Errorf("backend %q: missing socket path", address)
+		}
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		}
+		target = &url.URL{Scheme: "http", Host: "unix"}
+	case strings.Contains(address, "://"):
+		parsed, err := url.Parse(address)
+		if err != nil {
+			return nil, fmt.Errorf("backend %q: %w", address, err)
+		}
+		target = parsed
+	default:
+		target = &url.URL{Scheme: "http", Host: address}
+	}
+
+	b := &Backend{
+		name:        address,
+		target:      target,
+		transport:   transport,
+		maxFails:    int64(cfg.MaxFails),
+		failTimeout: cfg.FailTimeout,
+		requests:    metrics.NewCounter("lb_requests_total", "Requests proxied to a backend.", "backend", address),
+		errors:      metrics.NewCounter("lb_backend_errors_total", "Requests that failed to reach a backend.", "backend", address),
+		ejections:   metrics.NewCounter("lb_backend_ejections_total", "Times a backend was taken out of rotation.", "backend", address),
+		activeGauge: metrics.NewGauge("lb_backend_active_requests", "Requests in flight to a backend.", "backend", address),
+		up:          metrics.NewGauge("lb_backend_up", "Whether a backend is in rotation.", "backend", address),
+	}
+	b.up.Set(1)
+
+	b.proxy = &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			r.SetXForwarded()
+			r.Out.Host = r.In.Host
+		},
+		Transport:     transport,
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			// The client sent more than LB_MAX_BODY_BYTES, which says nothing
+			// about the backend.
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			b.errors.Inc()
+			if r.Context().Err() == nil {
+				b.fail()
+				slog.Warn("backend request failed", "backend", b.name, "error", err)
+			}
+			w.WriteHeader(http.StatusBadGateway)
+		},
+		ModifyResponse: func(*http.Response) error {
+			b.restore()
+			return nil
+		},
+	}
+
+	return b, nil
+}
+
+func (b *Backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.active.Add(1)
+	b.activeGauge.Add(1)
+	defer func() {
+		b.active.Add(-1)
+		b.activeGauge.Add(-1)
+	}()
+
+	b.requests.Inc()
+	b.proxy.ServeHTTP(w, r)
+}
+
+func (b *Backend) Available(now time.Time) bool {
+	return now.UnixNano() >= b.ejectedUntil.Load()
+}
+
+// fail counts a consecutive failure and, like nginx's max_fails, ejects the
+// backend for failTimeout once maxFails is reached.
+func (b *Backend) fail() {
+	if b.fails.Add(1) < b.maxFails {
+		return
+	}
+
+	b.fails.Store(0)
+	b.ejectedUntil.Store(time.Now().Add(b.failTimeout).UnixNano())
+	b.ejections.Inc()
+	b.up.Set(0)
+	slog.Warn("backend ejected", "backend", b.name, "for", b.failTimeout)
+}
+
+func (b *Backend) restore() {
+	b.fails.Store(0)
+	if b.ejectedUntil.Swap(0) != 0 {
+		b.up.Set(1)
+	}
+}
+
+// check probes path on the backend. A healthy answer puts an ejected backend
+// straight back into rotation instead of waiting out failTimeout.
+func (b *Backend) check(ctx context.Context, path string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.target.JoinPath(path).String(), nil)
+	if err != nil {
+		slog.Error("cannot build health check request", "backend", b.name, "error", err)
+		return
+	}
+
+	resp, err := b.transport.RoundTrip(req)
+	if err != nil {
+		b.fail()
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		b.fail()
+		return
+	}
+	b.restore()
+}
@@ -0,0 +1,84 @@
+package loadbalancer
+
+import (
+	"context"
+	"go-service/internal/config"
+	"go-service/internal/metrics"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var noBackend = metrics.NewCounter("lb_no_backend_total", "Requests served while every backend was ejected.")
+
+type Balancer struct {
+	cfg      config.LoadBalancerConfig
+	backends []*Backend
+	next     atomic.Uint64
+}
+
+func NewBalancer(cfg config.LoadBalancerConfig) (*Balancer, error) {
+	lb := &Balancer{cfg: cfg}
+	for _, address := range cfg.Backends {
+		backend, err := NewBackend(address, cfg)
+		if err != nil {
+			return nil, err
+		}
+		lb.backends = append(lb.backends, backend)
+	}
+	return lb, nil
+}
+
+func (lb *Balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if lb.cfg.MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, lb.cfg.MaxBodyBytes)
+	}
+	lb.pick().ServeHTTP(w, r)
+}
+
+// pick only considers backends in rotation. When all of them are ejected it
+// falls back to every backend, as nginx does, rather than failing outright.
+func (lb *Balancer) pick() *Backend {
+	now := time.Now()
+	candidates := make([]*Backend, 0, len(lb.backends))
+	for _, backend := range lb.backends {
+		if backend.Available(now) {
+			candidates = append(candidates, backend)
+		}
+	}
+	if len(candidates) == 0 {
+		noBackend.Inc()
+		candidates = lb.backends
+	}
+
+	offset := lb.next.Add(1)
+	if lb.cfg.Strategy == config.BalanceRoundRobin {
+		return candidates[offset%uint64(len(candidates))]
+	}
+
+	// Starting the scan at a rotating offset spreads ties between idle backends.
+	best := candidates[offset%uint64(len(candidates))]
+	for i := range candidates {
+		backend := candidates[(offset+uint64(i))%uint64(len(candidates))]
+		if backend.active.Load() < best.active.Load() {
+			best = backend
+		}
+	}
+	return best
+}
+
+func (lb *Balancer) RunHealthChecks(ctx context.Context) {
+	ticker := time.NewTicker(lb.cfg.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, backend := range lb.backends {
+				go backend.check(ctx, lb.cfg.HealthPath, lb.cfg.HealthInterval)
+			}
+		}
+	}
+}
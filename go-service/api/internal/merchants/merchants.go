@@ -0,0 +1,143 @@
+package merchants
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-service/internal/config"
+	"go-service/internal/ratelimit"
+	"sort"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	DefaultMerchantId = "default"
+	ApiKeyHeader      = "X-Api-Key"
+	AdminKeyHeader    = "X-Admin-Key"
+)
+
+var ErrUnknownApiKey = errors.New("unknown api key")
+
+type Merchant struct {
+	Id         string
+	Processors []string
+	limiter    ratelimit.Limiter
+}
+
+// KeyPrefix namespaces Redis keys per merchant. The default merchant keeps
+// the unprefixed keys so data written before merchants existed stays visible.
+func KeyPrefix(merchantId string) string {
+	if merchantId == "" || merchantId == DefaultMerchantId {
+		return ""
+	}
+	return "merchants:" + merchantId + ":"
+}
+
+type Registry struct {
+	byKey map[string]*Merchant
+	byId  map[string]*Merchant
+}
+
+func NewRegistry(cfg config.MerchantsConfig, rc *redis.Client) (*Registry, error) {
+	r := &Registry{
+		byKey: make(map[string]*Merchant),
+		byId: map[string]*Merchant{
+			DefaultMerchantId: {Id: DefaultMerchantId},
+		},
+	}
+
+	for _, mc := range cfg.Merchants {
+		if mc.Id == "" {
+			return nil, errors.New("merchant without id")
+		}
+
+		for _, processor := range mc.Processors {
+			if processor != config.DefaultProcessor && processor != config.FallbackProcessor {
+				return nil, fmt.Errorf("merchant %s: unknown processor %q", mc.Id, processor)
+			}
+		}
+
+		m := &Merchant{
+			Id:         mc.Id,
+			Processors: mc.Processors,
+		}
+
+		if mc.RateLimit > 0 {
+			burst := max(mc.Burst, 1)
+			if cfg.SharedLimits {
+				m.limiter = ratelimit.NewRedisTokenBucket(rc, KeyPrefix(mc.Id)+"ratelimit", mc.RateLimit, burst)
+			} else {
+				m.limiter = ratelimit.NewTokenBucket(mc.RateLimit, burst)
+			}
+		}
+
+		r.byId[mc.Id] = m
+
+		if mc.Id == DefaultMerchantId {
+			continue
+		}
+		if mc.ApiKey == "" {
+			return nil, fmt.Errorf("merchant %s has no api key", mc.Id)
+		}
+		if _, ok := r.byKey[mc.ApiKey]; ok {
+			return nil, fmt.Errorf("merchant %s reuses another merchant's api key", mc.Id)
+		}
+		r.byKey[mc.ApiKey] = m
+	}
+
+	return r, nil
+}
+
+func (r *Registry) Authenticate(apiKey string) (*Merchant, error) {
+	if apiKey == "" {
+		return r.byId[DefaultMerchantId], nil
+	}
+
+	m, ok := r.byKey[apiKey]
+	if !ok {
+		return nil, ErrUnknownApiKey
+	}
+	return m, nil
+}
+
+func (r *Registry) Get(id string) *Merchant {
+	if m, ok := r.byId[id]; ok {
+		return m
+	}
+	return r.byId[DefaultMerchantId]
+}
+
+// MultiTenant reports whether merchants other than the default one exist.
+func (r *Registry) MultiTenant() bool {
+	return len(r.byId) > 1
+}
+
+func (r *Registry) Ids() []string {
+	ids := make([]string, 0, len(r.byId))
+	for id := range r.byId {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (m *Merchant) Allow(ctx context.Context) (bool, error) {
+	if m.limiter == nil {
+		return true, nil
+	}
+	return m.limiter.Allow(ctx)
+}
+
+type contextKey struct{}
+
+func WithMerchant(ctx context.Context, m *Merchant) context.Context {
+	return context.WithValue(ctx, contextKey{}, m)
+}
+
+func FromContext(ctx context.Context) *Merchant {
+	if m, ok := ctx.Value(contextKey{}).(*Merchant); ok {
+		return m
+	}
+	return &Merchant{Id: DefaultMerchantId}
+}
@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Counter struct {
+	v atomic.Int64
+}
+
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+func (c *Counter) Add(n int64) {
+	c.v.Add(n)
+}
+
+func (c *Counter) value() float64 {
+	return float64(c.v.Load())
+}
+
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		if g.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (g *Gauge) value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+type series struct {
+	labels string
+	value  func() float64
+}
+
+type family struct {
+	name   string
+	help   string
+	kind   string
+	series map[string]series
+	values map[string]any
+}
+
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+	}
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.Counter(name, help, labels...)
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.Gauge(name, help, labels...)
+}
+
+// Counter returns the counter registered under name and the given label
+// pairs, creating it on first use.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return getOrCreate(r, name, help, "counter", labels, func() (*Counter, func() float64) {
+		c := &Counter{}
+		return c, c.value
+	})
+}
+
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return getOrCreate(r, name, help, "gauge", labels, func() (*Gauge, func() float64) {
+		g := &Gauge{}
+		return g, g.value
+	})
+}
+
+func getOrCreate[T any](r *Registry, name, help, kind string, labels []string, create func() (T, func() float64)) T {
+	key := formatLabels(labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{
+			name:   name,
+			help:   help,
+			kind:   kind,
+			series: make(map[string]series),
+			values: make(map[string]any),
+		}
+		r.families[name] = f
+	}
+
+	if existing, ok := f.values[key].(T); ok {
+		return existing
+	}
+
+	metric, value := create()
+	f.series[key] = series{labels: key, value: value}
+	f.values[key] = metric
+	return metric
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Fprintf(&b, "%s%s %g\n", f.name, key, f.series[key].value())
+		}
+	}
+	r.mu.Unlock()
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func Handler(r *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteTo(w)
+	}
+}
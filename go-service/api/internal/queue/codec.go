@@ -0,0 +1,268 @@
+package queue
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-service/internal/config"
+	"go-service/internal/dtos"
+	"math"
+	"time"
+)
+
+// Binary records start with a version byte. JSON records always start with
+// '{', so members written before the binary codec existed stay readable.
+// Flags are a uvarint: a new optional field takes the next flag bit, and
+// readers refuse records carrying bits they do not know instead of silently
+// dropping the field, so the version only changes when the layout itself does.
+const (
+	codecVersion byte = 1
+	jsonRecord   byte = '{'
+)
+
+const (
+	flagProcessor uint64 = 1 << iota
+	flagRetryCount
+	flagCallbackUrl
+	flagProcessedAt
+	flagMerchantId
+	flagCurrency
+	flagRetryDelay
+	flagSubmittedAt
+
+	knownFlags = flagSubmittedAt<<1 - 1
+)
+
+const (
+	processorOther byte = iota
+	processorDefault
+	processorFallback
+)
+
+var (
+	errTruncatedRecord = errors.New("truncated payment record")
+	errTrailingBytes   = errors.New("trailing bytes after payment record")
+)
+
+func encodePayment(p *dtos.Payment, codec string) ([]byte, error) {
+	if codec == config.QueueCodecJSON {
+		return json.Marshal(p)
+	}
+	return appendPayment(make([]byte, 0, 64), p), nil
+}
+
+func decodePayment(data []byte) (*dtos.Payment, error) {
+	if len(data) == 0 {
+		return nil, errTruncatedRecord
+	}
+
+	var p dtos.Payment
+	switch data[0] {
+	case jsonRecord:
+		if err := json.Unmarshal(data, &p); err != nil {
+			return nil, err
+		}
+	case codecVersion:
+		if err := readPayment(data[1:], &p); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown payment record version %d", data[0])
+	}
+	return &p, nil
+}
+
+// appendPayment writes a binary record: version, flags, correlationId, amount as
+// IEEE 754 bits, requestedAt in Unix nanoseconds, then the optional fields
+// named by flags in flag order. processedAt and submittedAt are stored relative
+// to requestedAt.
+func appendPayment(b []byte, p *dtos.Payment) []byte {
+	var flags uint64
+	if p.Processor != "" {
+		flags |= flagProcessor
+	}
+	if p.RetryCount != 0 {
+		flags |= flagRetryCount
+	}
+	if p.CallbackUrl != "" {
+		flags |= flagCallbackUrl
+	}
+	if !p.ProcessedAt.IsZero() {
+		flags |= flagProcessedAt
+	}
+	if p.MerchantId != "" {
+		flags |= flagMerchantId
+	}
+	if p.Currency != "" {
+		flags |= flagCurrency
+	}
+	if p.RetryDelay != 0 {
+		flags |= flagRetryDelay
+	}
+	if !p.SubmittedAt.IsZero() {
+		flags |= flagSubmittedAt
+	}
+
+	b = binary.AppendUvarint(append(b, codecVersion), flags)
+	b = appendString(b, p.CorrelationId)
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(p.Amount))
+	b = binary.AppendVarint(b, p.RequestedAt.UnixNano())
+
+	if flags&flagProcessor != 0 {
+		switch p.Processor {
+		case config.DefaultProcessor:
+			b = append(b, processorDefault)
+		case config.FallbackProcessor:
+			b = append(b, processorFallback)
+		default:
+			b = appendString(append(b, processorOther), p.Processor)
+		}
+	}
+	if flags&flagRetryCount != 0 {
+		b = binary.AppendVarint(b, int64(p.RetryCount))
+	}
+	if flags&flagCallbackUrl != 0 {
+		b = appendString(b, p.CallbackUrl)
+	}
+	if flags&flagProcessedAt != 0 {
+		b = binary.AppendVarint(b, p.ProcessedAt.UnixNano()-p.RequestedAt.UnixNano())
+	}
+	if flags&flagMerchantId != 0 {
+		b = appendString(b, p.MerchantId)
+	}
+	if flags&flagCurrency != 0 {
+		b = appendString(b, p.Currency)
+	}
+	if flags&flagRetryDelay != 0 {
+		b = binary.AppendVarint(b, int64(p.RetryDelay))
+	}
+	if flags&flagSubmittedAt != 0 {
+		b = binary.AppendVarint(b, p.SubmittedAt.UnixNano()-p.RequestedAt.UnixNano())
+	}
+
+	return b
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+type recordReader struct {
+	data []byte
+	err  error
+}
+
+func readPayment(data []byte, p *dtos.Payment) error {
+	r := &recordReader{data: data}
+
+	flags := r.uvarint()
+	if unknown := flags &^ knownFlags; unknown != 0 {
+		return fmt.Errorf("payment record has unknown flags %#x", unknown)
+	}
+
+	p.CorrelationId = r.string()
+	p.Amount = math.Float64frombits(r.uint64())
+	requestedAt := r.varint()
+	p.RequestedAt = time.Unix(0, requestedAt).UTC()
+
+	if flags&flagProcessor != 0 {
+		switch code := r.byte(); code {
+		case processorDefault:
+			p.Processor = config.DefaultProcessor
+		case processorFallback:
+			p.Processor = config.FallbackProcessor
+		case processorOther:
+			p.Processor = r.string()
+		default:
+			return fmt.Errorf("unknown processor code %d", code)
+		}
+	}
+	if flags&flagRetryCount != 0 {
+		p.RetryCount = int(r.varint())
+	}
+	if flags&flagCallbackUrl != 0 {
+		p.CallbackUrl = r.string()
+	}
+	if flags&flagProcessedAt != 0 {
+		p.ProcessedAt = time.Unix(0, requestedAt+r.varint()).UTC()
+	}
+	if flags&flagMerchantId != 0 {
+		p.MerchantId = r.string()
+	}
+	if flags&flagCurrency != 0 {
+		p.Currency = r.string()
+	}
+	if flags&flagRetryDelay != 0 {
+		p.RetryDelay = time.Duration(r.varint())
+	}
+	if flags&flagSubmittedAt != 0 {
+		p.SubmittedAt = time.Unix(0, requestedAt+r.varint()).UTC()
+	}
+
+	if r.err == nil && len(r.data) > 0 {
+		return errTrailingBytes
+	}
+	return r.err
+}
+
+func (r *recordReader) byte() byte {
+	if r.err != nil || len(r.data) < 1 {
+		r.err = errTruncatedRecord
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *recordReader) uint64() uint64 {
+	if r.err != nil || len(r.data) < 8 {
+		r.err = errTruncatedRecord
+		return 0
+	}
+	v := binary.LittleEndian.Uint64(r.data)
+	r.data = r.data[8:]
+	return v
+}
+
+func (r *recordReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = errTruncatedRecord
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *recordReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errTruncatedRecord
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *recordReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	length, n := binary.Uvarint(r.data)
+	if n <= 0 || uint64(len(r.data)-n) < length {
+		r.err = errTruncatedRecord
+		return ""
+	}
+	s := string(r.data[n : n+int(length)])
+	r.data = r.data[n+int(length):]
+	return s
+}
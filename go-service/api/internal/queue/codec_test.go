@@ -0,0 +1,160 @@
+package queue
+
+import (
+	"encoding/binary"
+	"errors"
+	"go-service/internal/config"
+	"go-service/internal/dtos"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var codecRequestedAt = time.Date(2025, 7, 15, 12, 30, 45, 123_000_000, time.UTC)
+
+var codecPayments = map[string]*dtos.Payment{
+	"minimal": {
+		CorrelationId: "4a7901b8-7d26-4d9d-aa19-4dc1c7cf60b3",
+		Amount:        19.9,
+		RequestedAt:   codecRequestedAt,
+	},
+	"processed": {
+		CorrelationId: "4a7901b8-7d26-4d9d-aa19-4dc1c7cf60b3",
+		Amount:        19.9,
+		RequestedAt:   codecRequestedAt,
+		SubmittedAt:   codecRequestedAt.Add(40 * time.Millisecond),
+		Processor:     config.FallbackProcessor,
+		RetryCount:    3,
+		RetryDelay:    750 * time.Millisecond,
+		CallbackUrl:   "https://example.com/hooks/payments",
+		ProcessedAt:   codecRequestedAt.Add(55 * time.Millisecond),
+		MerchantId:    "acme",
+		Currency:      "USD",
+	},
+	"other processor": {
+		CorrelationId: "c",
+		Amount:        0.01,
+		RequestedAt:   codecRequestedAt,
+		Processor:     "legacy",
+	},
+	"submitted before requested": {
+		CorrelationId: "c",
+		Amount:        1,
+		RequestedAt:   codecRequestedAt,
+		SubmittedAt:   codecRequestedAt.Add(-time.Second),
+	},
+}
+
+func TestBinaryCodecRoundTrip(t *testing.T) {
+	for name, payment := range codecPayments {
+		t.Run(name, func(t *testing.T) {
+			data, err := encodePayment(payment, config.QueueCodecBinary)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data[0] != codecVersion {
+				t.Fatalf("record starts with version %d, want %d", data[0], codecVersion)
+			}
+
+			decoded, err := decodePayment(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, payment) {
+				t.Fatalf("decoded %+v, want %+v", decoded, payment)
+			}
+		})
+	}
+}
+
+func TestJSONRecordsStillDecode(t *testing.T) {
+	for name, payment := range codecPayments {
+		t.Run(name, func(t *testing.T) {
+			data, err := encodePayment(payment, config.QueueCodecJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := decodePayment(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(decoded, payment) {
+				t.Fatalf("decoded %+v, want %+v", decoded, payment)
+			}
+		})
+	}
+
+	legacy := `{"correlationId":"c","amount":10.5,"requestedAt":"2025-07-15T12:30:45.123Z"}`
+	decoded, err := decodePayment([]byte(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.CorrelationId != "c" || decoded.Amount != 10.5 || !decoded.RequestedAt.Equal(codecRequestedAt) {
+		t.Fatalf("decoded legacy record as %+v", decoded)
+	}
+}
+
+func TestCodecRejectsUnknownFlags(t *testing.T) {
+	record := binary.AppendUvarint([]byte{codecVersion}, knownFlags+1)
+	record = appendString(record, "c")
+	record = binary.LittleEndian.AppendUint64(record, math.Float64bits(1))
+	record = binary.AppendVarint(record, codecRequestedAt.UnixNano())
+
+	if _, err := decodePayment(record); err == nil {
+		t.Fatal("decoded a record with an unknown flag")
+	}
+}
+
+func TestCodecRejectsMalformedRecords(t *testing.T) {
+	data, err := encodePayment(codecPayments["processed"], config.QueueCodecBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n := range len(data) {
+		if _, err := decodePayment(data[:n]); err == nil {
+			t.Errorf("decoded a record truncated to %d of %d bytes", n, len(data))
+		}
+	}
+
+	if _, err := decodePayment(append(data, 0)); !errors.Is(err, errTrailingBytes) {
+		t.Errorf("decoding a record with a trailing byte = %v, want errTrailingBytes", err)
+	}
+
+	if _, err := decodePayment([]byte{9, 0}); err == nil {
+		t.Error("decoded a record with an unknown version")
+	}
+}
+
+func BenchmarkCodec(b *testing.B) {
+	payment := codecPayments["processed"]
+
+	for _, codec := range []string{config.QueueCodecBinary, config.QueueCodecJSON} {
+		data, err := encodePayment(payment, codec)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(codec+"/encode", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := encodePayment(payment, codec); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(data)), "bytes/record")
+		})
+
+		b.Run(codec+"/decode", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := decodePayment(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(data)), "bytes/record")
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"go-service/internal/config"
+	"go-service/internal/dtos"
+	internalErrors "go-service/internal/errors"
+	"go-service/internal/merchants"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// compactScript takes KEYS processed, processed by submission, payloads,
+// rollups, rollups by submission, generation, compacted, then one status key
+// per member, and ARGV the number of rollups by request time R and by
+// submission time S, then R and S pairs of score and rollup, then the
+// members. It only commits when every member is still raw, so two compactors
+// racing on the same batch cannot count a payment twice. Members move to the
+// compacted set, keeping their requested time as score, so enqueueScript still
+// turns their correlationIds away until TrimCompacted lets them go.
+var compactScript = redis.NewScript(`
+local r, s = tonumber(ARGV[1]), tonumber(ARGV[2])
+local first = 3 + 2 * (r + s)
+local scores = {}
+for i = first, #ARGV do
+	scores[i] = redis.call('ZSCORE', KEYS[1], ARGV[i])
+	if not scores[i] then
+		return 0
+	end
+end
+for i = first, #ARGV do
+	redis.call('ZREM', KEYS[1], ARGV[i])
+	redis.call('ZREM', KEYS[2], ARGV[i])
+	redis.call('HDEL', KEYS[3], ARGV[i])
+	redis.call('ZADD', KEYS[7], scores[i], ARGV[i])
+	redis.call('DEL', KEYS[8 + i - first])
+end
+for i = 0, r - 1 do
+	redis.call('ZADD', KEYS[4], ARGV[3 + 2 * i], ARGV[4 + 2 * i])
+end
+for i = r, r + s - 1 do
+	redis.call('ZADD', KEYS[5], ARGV[3 + 2 * i], ARGV[4 + 2 * i])
+end
+redis.call('INCR', KEYS[6])
+return #ARGV - first + 1
+`)
+
+func rollupsKey(merchantId string) string {
+	return merchants.KeyPrefix(merchantId) + "payments:rollups"
+}
+
+func rollupsBySubmissionKey(merchantId string) string {
+	return merchants.KeyPrefix(merchantId) + "payments:rollups:submitted"
+}
+
+// compactedKey holds the correlationIds of compacted payments scored by
+// requested time, so they keep being refused on enqueue after their raw
+// entries are gone, until TrimCompacted drops them.
+func compactedKey(merchantId string) string {
+	return merchants.KeyPrefix(merchantId) + "payments:compacted"
+}
+
+// TrimCompacted forgets compacted correlationIds of payments requested before
+// before, after which a payment reusing one is accepted as new. It returns
+// how many ids were dropped.
+func (pq *PaymentQueue) TrimCompacted(ctx context.Context, before time.Time) (int, error) {
+	total := 0
+	for _, merchantId := range pq.merchantIds {
+		n, err := pq.rc.ZRemRangeByScore(ctx, compactedKey(merchantId), "-inf", "("+strconv.FormatInt(before.UnixMilli(), 10)).Result()
+		if err != nil {
+			return total, err
+		}
+		total += int(n)
+	}
+	return total, nil
+}
+
+// rollupGenerationKey is bumped by every compaction so readers combining raw
+// entries with rollups can tell whether payments moved between the two.
+func rollupGenerationKey(merchantId string) string {
+	return merchants.KeyPrefix(merchantId) + "payments:rollups:generation"
+}
+
+// Compact rolls up to limit processed payments per merchant requested before
+// before into buckets of the given size and deletes their raw entries,
+// payloads and status, keeping only their correlationIds so they are still
+// recognised as processed until TrimCompacted drops them. It returns how many
+// payments were compacted.
+func (pq *PaymentQueue) Compact(ctx context.Context, before time.Time, bucket time.Duration, limit int) (int, error) {
+	total := 0
+	for _, merchantId := range pq.merchantIds {
+		n, err := pq.compactMerchant(ctx, merchantId, before, bucket, limit)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func (pq *PaymentQueue) compactMerchant(ctx context.Context, merchantId string, before time.Time, bucket time.Duration, limit int) (int, error) {
+	members, err := pq.rc.ZRangeByScore(ctx, processedKey(merchantId), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   "(" + strconv.FormatInt(before.UnixMilli(), 10),
+		Count: int64(limit),
+	}).Result()
+	if err != nil || len(members) == 0 {
+		return 0, err
+	}
+
+	values, err := pq.rc.HMGet(ctx, payloadsKey(merchantId), members...).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	byRequest := newRollups(bucket)
+	bySubmission := newRollups(bucket)
+	keys := []string{
+		processedKey(merchantId),
+		processedBySubmissionKey(merchantId),
+		payloadsKey(merchantId),
+		rollupsKey(merchantId),
+		rollupsBySubmissionKey(merchantId),
+		rollupGenerationKey(merchantId),
+		compactedKey(merchantId),
+	}
+	compacted := make([]interface{}, 0, len(members))
+
+	for i, member := range members {
+		payload, ok := values[i].(string)
+		if !ok {
+			payload = member
+		}
+
+		// Undecodable members are left raw rather than silently dropped.
+		payment, err := decodePayment([]byte(payload))
+		if err != nil {
+			continue
+		}
+
+		submittedAt := payment.SubmittedAt
+		if submittedAt.IsZero() {
+			submittedAt = payment.RequestedAt
+		}
+		byRequest.add(payment.RequestedAt, payment)
+		bySubmission.add(submittedAt, payment)
+
+		keys = append(keys, statusKey(merchantId, payment.CorrelationId))
+		compacted = append(compacted, member)
+	}
+
+	if len(compacted) == 0 {
+		return 0, nil
+	}
+
+	requestArgs, err := byRequest.args()
+	if err != nil {
+		return 0, err
+	}
+	submissionArgs, err := bySubmission.args()
+	if err != nil {
+		return 0, err
+	}
+
+	args := make([]interface{}, 0, 2+len(requestArgs)+len(submissionArgs)+len(compacted))
+	args = append(args, len(requestArgs)/2, len(submissionArgs)/2)
+	args = append(args, requestArgs...)
+	args = append(args, submissionArgs...)
+	args = append(args, compacted...)
+
+	return compactScript.Run(ctx, pq.rc, keys, args...).Int()
+}
+
+type rollupTotalKey struct {
+	processor string
+	currency  string
+}
+
+type rollups struct {
+	bucket  time.Duration
+	buckets map[int64]map[rollupTotalKey]*dtos.RollupTotal
+	ids     map[int64]string
+}
+
+func newRollups(bucket time.Duration) *rollups {
+	return &rollups{
+		bucket:  bucket,
+		buckets: make(map[int64]map[rollupTotalKey]*dtos.RollupTotal),
+		ids:     make(map[int64]string),
+	}
+}
+
+// add files payment under the bucket containing at. The first payment of a
+// bucket names the rollup: it is deleted in the same step, so no later rollup
+// can reuse its correlationId and sorted set members stay unique.
+func (r *rollups) add(at time.Time, payment *dtos.Payment) {
+	start := at.Truncate(r.bucket).UnixMilli()
+
+	totals, ok := r.buckets[start]
+	if !ok {
+		totals = make(map[rollupTotalKey]*dtos.RollupTotal)
+		r.buckets[start] = totals
+		r.ids[start] = payment.CorrelationId
+	}
+
+	processor := payment.Processor
+	if processor == "" {
+		processor = config.DefaultProcessor
+	}
+	currency := payment.Currency
+	if currency == "" {
+		currency = config.DefaultCurrency
+	}
+
+	key := rollupTotalKey{processor, currency}
+	total, ok := totals[key]
+	if !ok {
+		total = &dtos.RollupTotal{Processor: processor, Currency: currency}
+		totals[key] = total
+	}
+	total.Count++
+	total.Amount += payment.Amount
+}
+
+func (r *rollups) args() ([]interface{}, error) {
+	args := make([]interface{}, 0, 2*len(r.buckets))
+	for start, totals := range r.buckets {
+		rollup := dtos.Rollup{
+			Id:     r.ids[start],
+			Start:  time.UnixMilli(start).UTC(),
+			Size:   r.bucket,
+			Totals: make([]dtos.RollupTotal, 0, len(totals)),
+		}
+		for _, total := range totals {
+			rollup.Totals = append(rollup.Totals, *total)
+		}
+
+		member, err := json.Marshal(rollup)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, start, member)
+	}
+	return args, nil
+}
+
+// ScanRollups walks rollups whose bucket lies inside the filter window. The
+// payments of a rollup are no longer known one by one, so a window that
+// starts or ends inside a rolled up bucket cannot be answered exactly and
+// fails with ErrSummaryWindowMisaligned instead of counting the bucket whole
+// or not at all.
+func (pq *PaymentQueue) ScanRollups(ctx context.Context, f dtos.GetPaymentsSummaryFiltersJson, batchSize int64, fn func(*dtos.Rollup) error) error {
+	key := rollupsKey(f.MerchantId)
+	if f.TimeField == dtos.TimeFieldSubmitted {
+		key = rollupsBySubmissionKey(f.MerchantId)
+	}
+
+	minScore, maxScore := scoreRange(f)
+	if minScore > maxScore {
+		return nil
+	}
+
+	// Buckets are as long as COMPACTION_BUCKET was when they were rolled up,
+	// so the one starting last before the window is the one that can reach
+	// into it.
+	previous, err := pq.rc.ZRevRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   "(" + strconv.FormatInt(minScore, 10),
+		Count: 1,
+	}).Result()
+	if err != nil {
+		return err
+	}
+	for _, member := range previous {
+		var rollup dtos.Rollup
+		if json.Unmarshal([]byte(member), &rollup) == nil && rollupEnd(&rollup) >= minScore {
+			return internalErrors.ErrSummaryWindowMisaligned
+		}
+	}
+
+	return pq.scanScores(ctx, key, minScore, maxScore, batchSize, func(members []string) error {
+		for _, member := range members {
+			var rollup dtos.Rollup
+			if err := json.Unmarshal([]byte(member), &rollup); err != nil {
+				continue
+			}
+
+			if rollupEnd(&rollup) > maxScore {
+				return internalErrors.ErrSummaryWindowMisaligned
+			}
+
+			if err := fn(&rollup); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rollupEnd returns the score of the last millisecond a rollup covers.
+func rollupEnd(rollup *dtos.Rollup) int64 {
+	return rollup.Start.UnixMilli() + max(rollup.Size.Milliseconds(), 1) - 1
+}
+
+func (pq *PaymentQueue) RollupGeneration(ctx context.Context, merchantId string) (int64, error) {
+	generation, err := pq.rc.Get(ctx, rollupGenerationKey(merchantId)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return generation, err
+}
@@ -1,14 +1,26 @@
 package queue
 
 import (
+	"context"
 	"go-service/internal/dtos"
+	"time"
 )
 
 type PaymentQueueInterface interface {
 	Enqueue(p *dtos.Payment) error
-	Dequeue() (*dtos.Payment, error)
-	RequeueWithBackoff(p *dtos.Payment) error
+	EnqueueBatch(ctx context.Context, ps []*dtos.Payment) error
+	Dequeue(ctx context.Context) (*dtos.Payment, error)
+	DequeueN(ctx context.Context, n int) ([]*dtos.Payment, error)
+	RequeueWithBackoff(p *dtos.Payment, delay time.Duration, cause error) error
+	DeadLetter(p *dtos.Payment, cause error) error
 	Acknowledge(p *dtos.Payment) error
-	Summary(f dtos.GetPaymentsSummaryFiltersJson) (*dtos.GetPaymentSummaryResponse, error)
+	AcknowledgeBatch(ps []*dtos.Payment) error
+	Scan(ctx context.Context, f dtos.GetPaymentsSummaryFiltersJson, batchSize int64, fn func(*dtos.Payment) error) error
+	ScanRollups(ctx context.Context, f dtos.GetPaymentsSummaryFiltersJson, batchSize int64, fn func(*dtos.Rollup) error) error
+	RollupGeneration(ctx context.Context, merchantId string) (int64, error)
+	Compact(ctx context.Context, before time.Time, bucket time.Duration, limit int) (int, error)
+	TrimCompacted(ctx context.Context, before time.Time) (int, error)
+	Status(ctx context.Context, merchantId, correlationId string) (*dtos.PaymentStatus, error)
+	Len(ctx context.Context) (int64, error)
 	Clear() error
 }
@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"go-service/internal/config"
+	"go-service/internal/dtos"
+	"go-service/internal/merchants"
+	"go-service/internal/metrics"
+	"sort"
+	"sync"
+)
+
+type lanes struct {
+	cfg     []config.LaneConfig
+	depth   []*metrics.Gauge
+	expired int
+
+	mu      sync.Mutex
+	current []int
+	total   int
+}
+
+func newLanes(cfg []config.LaneConfig) *lanes {
+	l := &lanes{
+		cfg:     cfg,
+		depth:   make([]*metrics.Gauge, len(cfg)),
+		current: make([]int, len(cfg)),
+	}
+
+	for i, lane := range cfg {
+		l.depth[i] = metrics.NewGauge("queue_lane_depth", "Payments waiting in each queue lane.", "lane", lane.Name)
+		l.total += lane.Weight
+	}
+
+	// Payments whose lease ran out already had an attempt, so they go back
+	// through the first retry lane.
+	for i, lane := range cfg {
+		if lane.Retries {
+			l.expired = i
+			break
+		}
+	}
+
+	return l
+}
+
+func (l *lanes) key(merchantId string, lane int) string {
+	if name := l.cfg[lane].Name; name != config.FreshLane {
+		return merchants.KeyPrefix(merchantId) + "payments:queue:" + name
+	}
+	return queueKey(merchantId)
+}
+
+func (l *lanes) keys(merchantId string) []string {
+	keys := make([]string, len(l.cfg))
+	for i := range l.cfg {
+		keys[i] = l.key(merchantId, i)
+	}
+	return keys
+}
+
+func (l *lanes) laneFor(p *dtos.Payment) int {
+	retry := p.RetryCount > 0
+	fallback := -1
+	for i, lane := range l.cfg {
+		if lane.Retries != retry {
+			continue
+		}
+		if p.Amount >= lane.MinAmount {
+			return i
+		}
+		if fallback < 0 {
+			fallback = i
+		}
+	}
+	return max(fallback, 0)
+}
+
+// schedule returns lane indexes in the order they should be served, with the
+// number of payments each may take from a batch of n before leftover capacity
+// goes to whichever lanes still have due payments. The first lane is picked by
+// smooth weighted round robin, so even single dequeues honour the weights.
+func (l *lanes) schedule(n int) ([]int, []int) {
+	l.mu.Lock()
+	first := 0
+	for i, lane := range l.cfg {
+		l.current[i] += lane.Weight
+		if l.current[i] > l.current[first] {
+			first = i
+		}
+	}
+	l.current[first] -= l.total
+	l.mu.Unlock()
+
+	order := make([]int, 0, len(l.cfg))
+	for i := range l.cfg {
+		if i != first {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return l.cfg[order[a]].Weight > l.cfg[order[b]].Weight
+	})
+	order = append([]int{first}, order...)
+
+	quotas := make([]int, len(order))
+	for i, lane := range order {
+		quotas[i] = max(1, n*l.cfg[lane].Weight/l.total)
+	}
+
+	return order, quotas
+}
@@ -2,24 +2,204 @@ package queue
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"go-service/internal/config"
 	"go-service/internal/dtos"
 	internalErrors "go-service/internal/errors"
+	"go-service/internal/merchants"
+	"log/slog"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
+// Queue, inflight, processed and dead-letter sets all use the correlationId as
+// member, and the latest payload for each payment lives in a separate hash.
+// Every transition below is a script that moves that one member between sets,
+// so a payment is never in two of them at once.
+
+// enqueueScript takes KEYS with the L lane queues first, then inflight,
+// processed, deadletter, payloads, wakeup, compacted and one status key per
+// payment. ARGV is L followed by groups of correlationId, lane, score,
+// payload, amount, currency, requestedAt. Payments already queued in any
+// lane, in flight, processed or compacted are left alone.
+var enqueueScript = redis.NewScript(`
+local lanes = tonumber(ARGV[1])
+local inflight, processed, deadletter, payloads, wakeup, compacted =
+	KEYS[lanes + 1], KEYS[lanes + 2], KEYS[lanes + 3], KEYS[lanes + 4], KEYS[lanes + 5], KEYS[lanes + 6]
+local added = 0
+for i = 0, (#ARGV - 1) / 7 - 1 do
+	local arg = i * 7 + 1
+	local cid = ARGV[arg + 1]
+	local queued = redis.call('ZSCORE', inflight, cid) or redis.call('ZSCORE', processed, cid)
+		or redis.call('ZSCORE', compacted, cid)
+	for lane = 1, lanes do
+		queued = queued or redis.call('ZSCORE', KEYS[lane], cid)
+	end
+	if not queued then
+		redis.call('ZREM', deadletter, cid)
+		redis.call('HSET', payloads, cid, ARGV[arg + 4])
+		redis.call('ZADD', KEYS[tonumber(ARGV[arg + 2])], ARGV[arg + 3], cid)
+		local status = KEYS[lanes + 7 + i]
+		redis.call('DEL', status)
+		redis.call('HSET', status, 'state', 'queued', 'amount', ARGV[arg + 5],
+			'currency', ARGV[arg + 6], 'requestedAt', ARGV[arg + 7])
+		added = added + 1
+	end
+end
+if added > 0 then
+	redis.call('LPUSH', wakeup, 1)
+	redis.call('LTRIM', wakeup, 0, 63)
+end
+return added
+`)
+
+// claimScript takes, for each merchant, KEYS inflight and payloads followed by
+// its L lane queues. ARGV is now, limit, lease deadline, L, the lane expired
+// leases return to, then L pairs of lane and quota in serving order. Expired
+// leases go back first; then each lane gives up to its quota of due payments
+// and any capacity left is filled from the lanes in the same order. It
+// returns the earliest pending score, empty when every queue is empty, then
+// the claimed payloads. Members written before payloads were split out are
+// returned as they are, since the member is the payload itself.
+var claimScript = redis.NewScript(`
+local now = ARGV[1]
+local limit = tonumber(ARGV[2])
+local lanes = tonumber(ARGV[4])
+local expiredLane = tonumber(ARGV[5])
+local stride = lanes + 2
+local result = {''}
+local nextDue = nil
+
+local order, quota = {}, {}
+for j = 0, lanes - 1 do
+	order[j + 1] = tonumber(ARGV[6 + j * 2])
+	quota[j + 1] = tonumber(ARGV[7 + j * 2])
+end
+
+local function take(base, lane, count)
+	count = math.min(count, limit - #result + 1)
+	if count <= 0 then
+		return 0
+	end
+	local queue = KEYS[base + 2 + lane]
+	local due = redis.call('ZRANGEBYSCORE', queue, '-inf', now, 'LIMIT', 0, count)
+	for _, cid in ipairs(due) do
+		redis.call('ZREM', queue, cid)
+		local payload = redis.call('HGET', KEYS[base + 2], cid)
+		if payload then
+			redis.call('ZADD', KEYS[base + 1], ARGV[3], cid)
+			table.insert(result, payload)
+		else
+			table.insert(result, cid)
+		end
+	end
+	return #due
+end
+
+for base = 0, #KEYS - 1, stride do
+	for _, cid in ipairs(redis.call('ZRANGEBYSCORE', KEYS[base + 1], '-inf', now)) do
+		redis.call('ZREM', KEYS[base + 1], cid)
+		redis.call('ZADD', KEYS[base + 2 + expiredLane], now, cid)
+	end
+end
+
+for base = 0, #KEYS - 1, stride do
+	for j = 1, lanes do
+		quota[j] = quota[j] - take(base, order[j], quota[j])
+	end
+end
+
+for base = 0, #KEYS - 1, stride do
+	for j = 1, lanes do
+		take(base, order[j], limit)
+	end
+	for lane = 1, lanes do
+		local head = redis.call('ZRANGE', KEYS[base + 2 + lane], 0, 0, 'WITHSCORES')
+		if head[2] and (nextDue == nil or tonumber(head[2]) < nextDue) then
+			nextDue = tonumber(head[2])
+		end
+	end
+end
+
+if nextDue then
+	result[1] = string.format('%d', nextDue)
+end
+return result
+`)
+
+// ackScript takes KEYS inflight, processed, processed by submission,
+// payloads, the L lane queues, then one status key per payment, and ARGV
+// processedAt, L, status TTL in milliseconds (0 for none), then groups of
+// correlationId, requested score, submitted score, submittedAt, payload,
+// processor. Removing the payment from every lane covers an acknowledgement
+// that arrives after its lease ran out.
+var ackScript = redis.NewScript(`
+local lanes = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+for i = 0, (#ARGV - 3) / 6 - 1 do
+	local arg = i * 6 + 3
+	local cid = ARGV[arg + 1]
+	for lane = 1, lanes do
+		redis.call('ZREM', KEYS[4 + lane], cid)
+	end
+	redis.call('ZREM', KEYS[1], cid)
+	redis.call('ZADD', KEYS[2], ARGV[arg + 2], cid)
+	redis.call('ZADD', KEYS[3], ARGV[arg + 3], cid)
+	redis.call('HSET', KEYS[4], cid, ARGV[arg + 5])
+	local status = KEYS[4 + lanes + 1 + i]
+	redis.call('HSET', status, 'state', 'processed', 'processor', ARGV[arg + 6],
+		'processedAt', ARGV[1], 'submittedAt', ARGV[arg + 4], 'nextAttemptAt', '', 'error', '')
+	if ttl > 0 then
+		redis.call('PEXPIRE', status, ttl)
+	end
+end
+return (#ARGV - 3) / 6
+`)
+
+// moveScript takes KEYS inflight, target, payloads, status and optionally
+// wakeup, and ARGV correlationId, score, payload, status TTL in milliseconds
+// (0 for none), then status field/value pairs. It only moves a payment this
+// worker still holds: once its lease expired the payment went back to the
+// queue and belongs to whoever claims it.
+var moveScript = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[1])
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[3])
+redis.call('HSET', KEYS[4], unpack(ARGV, 5))
+if tonumber(ARGV[4]) > 0 then
+	redis.call('PEXPIRE', KEYS[4], ARGV[4])
+end
+if KEYS[5] then
+	redis.call('LPUSH', KEYS[5], 1)
+	redis.call('LTRIM', KEYS[5], 0, 63)
+end
+return 1
+`)
+
+const wakeupKey = "payments:wakeup"
+
 type PaymentQueue struct {
-	rc *redis.Client
+	rc          *redis.Client
+	cfg         config.QueueConfig
+	lanes       *lanes
+	merchantIds []string
+	rotation    atomic.Uint64
 }
 
-func NewPaymentQueue(rc *redis.Client) (*PaymentQueue, error) {
+func NewPaymentQueue(rc *redis.Client, cfg config.QueueConfig, merchantIds []string) (*PaymentQueue, error) {
+	if len(merchantIds) == 0 {
+		merchantIds = []string{merchants.DefaultMerchantId}
+	}
+
 	return &PaymentQueue{
-		rc: rc,
+		rc:          rc,
+		cfg:         cfg,
+		lanes:       newLanes(cfg.Lanes),
+		merchantIds: merchantIds,
 	}, nil
 }
 
@@ -28,114 +208,355 @@ func (pq *PaymentQueue) Close() error {
 }
 
 func (pq *PaymentQueue) Enqueue(p *dtos.Payment) error {
-	ctx := context.Background()
+	return pq.EnqueueBatch(context.Background(), []*dtos.Payment{p})
+}
 
-	key := "payments:queue"
-	payload, err := json.Marshal(p)
-	if err != nil {
-		return err
+func (pq *PaymentQueue) EnqueueBatch(ctx context.Context, ps []*dtos.Payment) error {
+	for merchantId, group := range byMerchant(ps) {
+		keys := append(pq.lanes.keys(merchantId),
+			inflightKey(merchantId),
+			processedKey(merchantId),
+			deadLetterKey(merchantId),
+			payloadsKey(merchantId),
+			wakeupKey,
+			compactedKey(merchantId),
+		)
+		args := make([]interface{}, 1, 1+7*len(group))
+		args[0] = len(pq.cfg.Lanes)
+
+		for _, p := range group {
+			payload, err := encodePayment(p, pq.cfg.Codec)
+			if err != nil {
+				return err
+			}
+
+			keys = append(keys, statusKey(merchantId, p.CorrelationId))
+			args = append(args,
+				p.CorrelationId,
+				pq.lanes.laneFor(p)+1,
+				p.RequestedAt.UnixMilli(),
+				payload,
+				p.Amount,
+				p.Currency,
+				p.RequestedAt.UTC().Format(config.DateTimeFormat),
+			)
+		}
+
+		if err := enqueueScript.Run(ctx, pq.rc, keys, args...).Err(); err != nil {
+			return err
+		}
 	}
 
-	pipe := pq.rc.Pipeline()
-	pipe.ZAdd(ctx, key, &redis.Z{
-		Score:  float64(p.RequestedAt.UnixMilli()),
-		Member: payload,
-	})
-	_, err = pipe.Exec(ctx)
-	return err
+	return nil
 }
 
-func (pq *PaymentQueue) Dequeue() (*dtos.Payment, error) {
-	ctx := context.Background()
-	key := "payments:queue"
+func byMerchant(ps []*dtos.Payment) map[string][]*dtos.Payment {
+	groups := make(map[string][]*dtos.Payment)
+	for _, p := range ps {
+		groups[p.MerchantId] = append(groups[p.MerchantId], p)
+	}
+	return groups
+}
 
-	pipe := pq.rc.Pipeline()
-	popCmd := pipe.ZPopMin(ctx, key, 1)
-	_, err := pipe.Exec(ctx)
+func (pq *PaymentQueue) Dequeue(ctx context.Context) (*dtos.Payment, error) {
+	payments, err := pq.DequeueN(ctx, 1)
 	if err != nil {
-		if err == redis.Nil {
+		return nil, err
+	}
+	return payments[0], nil
+}
+
+// DequeueN claims up to n due payments. When none are due it sleeps until the
+// next retry is due or blocks on the wakeup list that enqueues push to, and
+// gives up with ErrNoPaymentsInQueue after DequeueTimeout.
+func (pq *PaymentQueue) DequeueN(ctx context.Context, n int) ([]*dtos.Payment, error) {
+	deadline := time.Now().Add(config.DequeueTimeout)
+
+	for {
+		payments, nextDue, err := pq.claim(ctx, max(n, 1))
+		if err != nil || len(payments) > 0 {
+			return payments, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
 			return nil, internalErrors.ErrNoPaymentsInQueue
 		}
-		return nil, err
+
+		if !nextDue.IsZero() && time.Until(nextDue) < remaining {
+			timer := time.NewTimer(max(time.Until(nextDue), time.Millisecond))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+			continue
+		}
+
+		if err := pq.rc.BLPop(ctx, config.DequeueTimeout, wakeupKey).Err(); err != nil {
+			if err == redis.Nil {
+				return nil, internalErrors.ErrNoPaymentsInQueue
+			}
+			return nil, err
+		}
 	}
+}
 
-	result := popCmd.Val()
-	if len(result) == 0 {
-		return nil, internalErrors.ErrNoPaymentsInQueue
+func (pq *PaymentQueue) claim(ctx context.Context, n int) ([]*dtos.Payment, time.Time, error) {
+	merchantIds := pq.rotatedMerchantIds()
+	keys := make([]string, 0, (2+len(pq.cfg.Lanes))*len(merchantIds))
+	for _, id := range merchantIds {
+		keys = append(keys, inflightKey(id), payloadsKey(id))
+		keys = append(keys, pq.lanes.keys(id)...)
 	}
 
-	var payment dtos.Payment
-	memberStr, ok := result[0].Member.(string)
-	if !ok {
-		return nil, errors.New("invalid member type in queue")
+	now := time.Now()
+	leaseUntil := now.Add(pq.cfg.Lease).UnixMilli()
+	args := []interface{}{now.UnixMilli(), n, leaseUntil, len(pq.cfg.Lanes), pq.lanes.expired + 1}
+	order, quotas := pq.lanes.schedule(n)
+	for i, lane := range order {
+		args = append(args, lane+1, quotas[i])
 	}
-	if err := json.Unmarshal([]byte(memberStr), &payment); err != nil {
-		return nil, err
+
+	result, err := claimScript.Run(ctx, pq.rc, keys, args...).StringSlice()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var nextDue time.Time
+	if len(result) > 0 && result[0] != "" {
+		if score, err := strconv.ParseInt(result[0], 10, 64); err == nil {
+			nextDue = time.UnixMilli(score)
+		}
+	}
+
+	// A record that fails to decode cannot be traced back to its payment, so
+	// it is logged and skipped; the others were claimed with it and must not
+	// wait out their lease because of it.
+	payments := make([]*dtos.Payment, 0, len(result))
+	payloads := make([]string, 0, len(result))
+	for _, payload := range result[1:] {
+		payment, err := decodePayment([]byte(payload))
+		if err != nil {
+			slog.Error("skipping undecodable payment record", "bytes", len(payload), "error", err)
+			continue
+		}
+		payments = append(payments, payment)
+		payloads = append(payloads, payload)
+	}
+
+	if len(payments) > 0 {
+		if err := pq.markInFlight(ctx, leaseUntil, payments, payloads); err != nil {
+			return payments, nextDue, err
+		}
+	}
+
+	return payments, nextDue, nil
+}
+
+// markInFlight also leases members that predate the payloads hash, which the
+// claim script hands back without tracking; for the others both writes are
+// no-ops.
+func (pq *PaymentQueue) markInFlight(ctx context.Context, leaseUntil int64, payments []*dtos.Payment, payloads []string) error {
+	pipe := pq.rc.Pipeline()
+	for i, p := range payments {
+		pipe.ZAddNX(ctx, inflightKey(p.MerchantId), &redis.Z{Score: float64(leaseUntil), Member: p.CorrelationId})
+		pipe.HSetNX(ctx, payloadsKey(p.MerchantId), p.CorrelationId, payloads[i])
+		pipe.HSet(ctx, statusKey(p.MerchantId, p.CorrelationId), "state", dtos.PaymentStateInFlight)
 	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
 
-	return &payment, nil
+func (pq *PaymentQueue) rotatedMerchantIds() []string {
+	offset := int(pq.rotation.Add(1) % uint64(len(pq.merchantIds)))
+	return append(pq.merchantIds[offset:len(pq.merchantIds):len(pq.merchantIds)], pq.merchantIds[:offset]...)
 }
 
-func (pq *PaymentQueue) getQueueKey() string {
-	return "payments:queue"
+func queueKey(merchantId string) string {
+	return merchants.KeyPrefix(merchantId) + "payments:queue"
 }
 
-func (pq *PaymentQueue) RequeueWithBackoff(p *dtos.Payment) error {
-	ctx := context.Background()
-	key := pq.getQueueKey()
+func inflightKey(merchantId string) string {
+	return merchants.KeyPrefix(merchantId) + "payments:inflight"
+}
+
+func processedKey(merchantId string) string {
+	return merchants.KeyPrefix(merchantId) + "payments:processed"
+}
+
+// processedBySubmissionKey indexes the same members as processedKey, scored
+// by when the payment was submitted to the processor that accepted it.
+func processedBySubmissionKey(merchantId string) string {
+	return merchants.KeyPrefix(merchantId) + "payments:processed:submitted"
+}
+
+func deadLetterKey(merchantId string) string {
+	return merchants.KeyPrefix(merchantId) + "payments:deadletter"
+}
+
+func payloadsKey(merchantId string) string {
+	return merchants.KeyPrefix(merchantId) + "payments:payloads"
+}
+
+func statusKey(merchantId, correlationId string) string {
+	return merchants.KeyPrefix(merchantId) + "payments:status:" + correlationId
+}
+
+func (pq *PaymentQueue) RequeueWithBackoff(p *dtos.Payment, delay time.Duration, cause error) error {
 	p.RetryCount++
+	p.RetryDelay = delay
 
-	delayMs := int64(30 * (1 << p.RetryCount))
-	if delayMs > 5000 {
-		delayMs = 5000
-	}
+	nextAttemptAt := time.Now().Add(delay)
 
-	score := float64(time.Now().UnixMilli() + delayMs)
+	return pq.move(p, pq.lanes.key(p.MerchantId, pq.lanes.laneFor(p)), wakeupKey, nextAttemptAt, 0,
+		"state", dtos.PaymentStateRetrying,
+		"retryCount", p.RetryCount,
+		"nextAttemptAt", nextAttemptAt.UTC().Format(config.DateTimeFormat),
+		"error", errorMessage(cause),
+	)
+}
 
-	payload, err := json.Marshal(p)
+func (pq *PaymentQueue) DeadLetter(p *dtos.Payment, cause error) error {
+	return pq.move(p, deadLetterKey(p.MerchantId), "", time.Now(), pq.cfg.StatusTTL,
+		"state", dtos.PaymentStateDeadLettered,
+		"retryCount", p.RetryCount,
+		"nextAttemptAt", "",
+		"error", errorMessage(cause),
+	)
+}
+
+func (pq *PaymentQueue) move(p *dtos.Payment, target, wakeup string, at time.Time, statusTTL time.Duration, status ...interface{}) error {
+	payload, err := encodePayment(p, pq.cfg.Codec)
 	if err != nil {
 		return err
 	}
 
-	pipe := pq.rc.Pipeline()
-	pipe.ZAdd(ctx, key, &redis.Z{
-		Score:  score,
-		Member: payload,
-	})
-	_, err = pipe.Exec(ctx)
-	return err
+	keys := []string{
+		inflightKey(p.MerchantId),
+		target,
+		payloadsKey(p.MerchantId),
+		statusKey(p.MerchantId, p.CorrelationId),
+	}
+	if wakeup != "" {
+		keys = append(keys, wakeup)
+	}
+	args := append([]interface{}{p.CorrelationId, at.UnixMilli(), payload, statusTTL.Milliseconds()}, status...)
+
+	return moveScript.Run(context.Background(), pq.rc, keys, args...).Err()
+}
+
+func errorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
 }
 
 func (pq *PaymentQueue) Acknowledge(p *dtos.Payment) error {
+	return pq.AcknowledgeBatch([]*dtos.Payment{p})
+}
+
+func (pq *PaymentQueue) AcknowledgeBatch(ps []*dtos.Payment) error {
 	ctx := context.Background()
+	processedAt := time.Now().UTC().Format(config.DateTimeFormat)
+
+	for merchantId, group := range byMerchant(ps) {
+		keys := append([]string{
+			inflightKey(merchantId),
+			processedKey(merchantId),
+			processedBySubmissionKey(merchantId),
+			payloadsKey(merchantId),
+		}, pq.lanes.keys(merchantId)...)
+		args := make([]interface{}, 3, 3+6*len(group))
+		args[0] = processedAt
+		args[1] = len(pq.cfg.Lanes)
+		args[2] = pq.cfg.StatusTTL.Milliseconds()
+
+		for _, p := range group {
+			payload, err := encodePayment(p, pq.cfg.Codec)
+			if err != nil {
+				return err
+			}
+
+			submittedAt := p.SubmittedAt
+			if submittedAt.IsZero() {
+				submittedAt = p.RequestedAt
+			}
 
-	queueKey := pq.getQueueKey()
-	processedKey := "payments:processed"
+			keys = append(keys, statusKey(merchantId, p.CorrelationId))
+			args = append(args,
+				p.CorrelationId,
+				p.RequestedAt.UnixMilli(),
+				submittedAt.UnixMilli(),
+				submittedAt.UTC().Format(config.DateTimeFormat),
+				payload,
+				p.Processor,
+			)
+		}
 
-	payload, err := json.Marshal(p)
+		if err := ackScript.Run(ctx, pq.rc, keys, args...).Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (pq *PaymentQueue) Status(ctx context.Context, merchantId, correlationId string) (*dtos.PaymentStatus, error) {
+	fields, err := pq.rc.HGetAll(ctx, statusKey(merchantId, correlationId)).Result()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	pipe := pq.rc.TxPipeline()
+	if len(fields) == 0 {
+		return nil, internalErrors.ErrPaymentNotFound
+	}
 
-	pipe.ZRem(ctx, queueKey, payload)
+	status := &dtos.PaymentStatus{
+		CorrelationId: correlationId,
+		State:         fields["state"],
+		Currency:      fields["currency"],
+		Processor:     fields["processor"],
+		Error:         fields["error"],
+	}
+	status.Amount, _ = strconv.ParseFloat(fields["amount"], 64)
+	status.RetryCount, _ = strconv.Atoi(fields["retryCount"])
+	status.RequestedAt, _ = time.Parse(config.DateTimeFormat, fields["requestedAt"])
+	status.SubmittedAt, _ = time.Parse(config.DateTimeFormat, fields["submittedAt"])
+	status.NextAttemptAt, _ = time.Parse(config.DateTimeFormat, fields["nextAttemptAt"])
+	status.ProcessedAt, _ = time.Parse(config.DateTimeFormat, fields["processedAt"])
+	if status.Currency == "" {
+		status.Currency = config.DefaultCurrency
+	}
 
-	pipe.ZAdd(ctx, processedKey, &redis.Z{
-		Score:  float64(p.RequestedAt.UnixMilli()),
-		Member: payload,
-	})
+	return status, nil
+}
 
-	results, err := pipe.Exec(ctx)
-	if err != nil {
-		return err
+func (pq *PaymentQueue) Len(ctx context.Context) (int64, error) {
+	pipe := pq.rc.Pipeline()
+	cmds := make([][]*redis.IntCmd, len(pq.cfg.Lanes))
+	for lane := range pq.cfg.Lanes {
+		for _, id := range pq.merchantIds {
+			cmds[lane] = append(cmds[lane], pipe.ZCard(ctx, pq.lanes.key(id, lane)))
+		}
 	}
 
-	if len(results) != 2 {
-		return errors.New("unexpected number of transaction results")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
 	}
 
-	return nil
+	var total int64
+	for lane, laneCmds := range cmds {
+		var depth int64
+		for _, cmd := range laneCmds {
+			depth += cmd.Val()
+		}
+		pq.lanes.depth[lane].Set(float64(depth))
+		total += depth
+	}
+	return total, nil
 }
 
 func (pq *PaymentQueue) Clear() error {
@@ -144,75 +565,118 @@ func (pq *PaymentQueue) Clear() error {
 	return pq.rc.FlushDB(ctx).Err()
 }
 
-func (pq *PaymentQueue) Summary(f dtos.GetPaymentsSummaryFiltersJson) (*dtos.GetPaymentSummaryResponse, error) {
-	ctx := context.Background()
-	key := "payments:processed"
+// Scan walks processed payments in score order, fetching batchSize members
+// at a time so callers can stream arbitrarily large ranges. The submission
+// index only holds payments acknowledged since it was introduced.
+func (pq *PaymentQueue) Scan(ctx context.Context, f dtos.GetPaymentsSummaryFiltersJson, batchSize int64, fn func(*dtos.Payment) error) error {
+	key := processedKey(f.MerchantId)
+	if f.TimeField == dtos.TimeFieldSubmitted {
+		key = processedBySubmissionKey(f.MerchantId)
+	}
+	payloads := payloadsKey(f.MerchantId)
 
-	now := time.Now()
-	var minScore, maxScore float64 = float64(0), float64(now.UnixMilli())
+	minScore, maxScore := scoreRange(f)
+	if minScore > maxScore {
+		return nil
+	}
 
-	if f.From != "" {
-		if fromTime, err := time.Parse(config.DateTimeFormat, f.From); err == nil {
-			minScore = float64(fromTime.UnixMilli())
+	return pq.scanScores(ctx, key, minScore, maxScore, batchSize, func(members []string) error {
+		values, err := pq.rc.HMGet(ctx, payloads, members...).Result()
+		if err != nil {
+			return err
 		}
-	}
 
-	if f.To != "" {
-		if toTime, err := time.Parse(config.DateTimeFormat, f.To); err == nil {
-			maxScore = float64(toTime.UnixMilli())
+		for i, member := range members {
+			// Members written before payloads moved to their own hash are the payload.
+			payload, ok := values[i].(string)
+			if !ok {
+				payload = member
+			}
+
+			payment, err := decodePayment([]byte(payload))
+			if err != nil {
+				continue
+			}
+
+			if err := fn(payment); err != nil {
+				return err
+			}
 		}
-	}
+		return nil
+	})
+}
 
-	var defaultSummary, fallbackSummary dtos.PaymentSummary
-	const batchSize = 10000
-	var offset int64 = 0
+// scanScores hands fn the members of key scored minScore to maxScore in
+// score order, batchSize at a time. Each page resumes from the last score
+// returned and skips the members already handed over at that score, rather
+// than counting an offset, so members added or removed while a long scan
+// runs never make it repeat or skip the others.
+func (pq *PaymentQueue) scanScores(ctx context.Context, key string, minScore, maxScore, batchSize int64, fn func([]string) error) error {
+	seen := make(map[string]struct{})
 
 	for {
-		payments, err := pq.rc.ZRangeByScore(ctx, key, &redis.ZRangeBy{
-			Min:    strconv.FormatFloat(minScore, 'f', 0, 64),
-			Max:    strconv.FormatFloat(maxScore, 'f', 0, 64),
-			Offset: offset,
-			Count:  batchSize,
+		count := batchSize + int64(len(seen))
+		page, err := pq.rc.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+			Min:   strconv.FormatInt(minScore, 10),
+			Max:   strconv.FormatInt(maxScore, 10),
+			Count: count,
 		}).Result()
-
 		if err != nil {
-			return nil, err
-		}
-
-		if len(payments) == 0 {
-			break
+			return err
 		}
 
-		for _, paymentStr := range payments {
-			var payment dtos.Payment
-			if err := json.Unmarshal([]byte(paymentStr), &payment); err != nil {
+		members := make([]string, 0, len(page))
+		for _, z := range page {
+			member, _ := z.Member.(string)
+			if score := int64(z.Score); score != minScore {
+				minScore = score
+				clear(seen)
+			} else if _, ok := seen[member]; ok {
 				continue
 			}
+			seen[member] = struct{}{}
+			members = append(members, member)
+		}
 
-			processor := payment.Processor
-			if processor == "" {
-				processor = "default"
+		if len(members) > 0 {
+			if err := fn(members); err != nil {
+				return err
 			}
+		}
 
-			switch processor {
-			case "default":
-				defaultSummary.TotalRequests++
-				defaultSummary.TotalAmount += payment.Amount
-			case "fallback":
-				fallbackSummary.TotalRequests++
-				fallbackSummary.TotalAmount += payment.Amount
-			}
+		if int64(len(page)) < count {
+			return nil
 		}
+	}
+}
 
-		offset += int64(len(payments))
+// scoreRange turns the filter window into inclusive millisecond scores.
+// Bounds may carry sub-millisecond precision, so inclusive lower bounds round
+// up and inclusive upper bounds round down to whole milliseconds.
+func scoreRange(f dtos.GetPaymentsSummaryFiltersJson) (int64, int64) {
+	var minScore, maxScore int64 = 0, time.Now().UnixMilli()
 
-		if len(payments) < batchSize {
-			break
+	if f.From != "" {
+		if fromTime, err := time.Parse(time.RFC3339Nano, f.From); err == nil {
+			ms := fromTime.UnixNano() / int64(time.Millisecond)
+			exact := fromTime.UnixNano()%int64(time.Millisecond) == 0
+			if f.FromExclusive || !exact {
+				ms++
+			}
+			minScore = ms
 		}
 	}
 
-	return &dtos.GetPaymentSummaryResponse{
-		Default:  defaultSummary,
-		Fallback: fallbackSummary,
-	}, nil
+	if f.To != "" {
+		if toTime, err := time.Parse(time.RFC3339Nano, f.To); err == nil {
+			ms := toTime.UnixNano() / int64(time.Millisecond)
+			exact := toTime.UnixNano()%int64(time.Millisecond) == 0
+			if f.ToExclusive && exact {
+				ms--
+			}
+			maxScore = ms
+		}
+	}
+
+	return minScore, maxScore
 }
@@ -0,0 +1,12 @@
+package ratelimit
+
+import "context"
+
+type Limiter interface {
+	Wait(ctx context.Context) error
+	Allow(ctx context.Context) (bool, error)
+}
+
+type Semaphore interface {
+	Acquire(ctx context.Context) (release func(), err error)
+}
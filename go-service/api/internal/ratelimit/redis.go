@@ -0,0 +1,145 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const pollInterval = 5 * time.Millisecond
+
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+
+tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tokens, 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return wait
+`)
+
+var semaphoreScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[1]) then
+	redis.call('ZADD', KEYS[1], now + tonumber(ARGV[2]), ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+type RedisTokenBucket struct {
+	rc    *redis.Client
+	key   string
+	rate  float64
+	burst int
+}
+
+func NewRedisTokenBucket(rc *redis.Client, key string, rate float64, burst int) *RedisTokenBucket {
+	return &RedisTokenBucket{
+		rc:    rc,
+		key:   key,
+		rate:  rate,
+		burst: burst,
+	}
+}
+
+func (tb *RedisTokenBucket) Wait(ctx context.Context) error {
+	for {
+		wait, err := tokenBucketScript.Run(ctx, tb.rc, []string{tb.key}, tb.rate, tb.burst).Int64()
+		if err != nil {
+			return fmt.Errorf("running token bucket script: %w", err)
+		}
+
+		if wait <= 0 {
+			return nil
+		}
+
+		if err := sleep(ctx, time.Duration(wait)*time.Millisecond); err != nil {
+			return err
+		}
+	}
+}
+
+func (tb *RedisTokenBucket) Allow(ctx context.Context) (bool, error) {
+	wait, err := tokenBucketScript.Run(ctx, tb.rc, []string{tb.key}, tb.rate, tb.burst).Int64()
+	if err != nil {
+		return false, fmt.Errorf("running token bucket script: %w", err)
+	}
+	return wait <= 0, nil
+}
+
+// RedisSemaphore hands out leases instead of plain counters so slots held by
+// a replica that crashed are reclaimed once the lease expires.
+type RedisSemaphore struct {
+	rc    *redis.Client
+	key   string
+	size  int
+	lease time.Duration
+}
+
+var semaphoreTokens atomic.Int64
+
+func NewRedisSemaphore(rc *redis.Client, key string, size int, lease time.Duration) *RedisSemaphore {
+	return &RedisSemaphore{
+		rc:    rc,
+		key:   key,
+		size:  size,
+		lease: lease,
+	}
+}
+
+func (s *RedisSemaphore) Acquire(ctx context.Context) (func(), error) {
+	hostname, _ := os.Hostname()
+	token := fmt.Sprintf("%s:%d", hostname, semaphoreTokens.Add(1))
+
+	for {
+		acquired, err := semaphoreScript.Run(ctx, s.rc, []string{s.key}, s.size, s.lease.Milliseconds(), token).Int()
+		if err != nil {
+			return nil, fmt.Errorf("running semaphore script: %w", err)
+		}
+
+		if acquired == 1 {
+			return func() {
+				s.rc.ZRem(context.Background(), s.key, token)
+			}, nil
+		}
+
+		if err := sleep(ctx, pollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"go-service/internal/dtos"
+	"io"
+	"strconv"
+	"sync"
+)
+
+const maxPooledBodySize = 64 << 10
+
+var bodyPool = sync.Pool{
+	New: func() any {
+		return bytes.NewBuffer(make([]byte, 0, 512))
+	},
+}
+
+// readBody reads r into a pooled buffer. The caller must hand the buffer back
+// with releaseBody once it no longer references the bytes.
+func readBody(r io.Reader) (*bytes.Buffer, error) {
+	buf := bodyPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	if _, err := buf.ReadFrom(r); err != nil {
+		releaseBody(buf)
+		return nil, err
+	}
+	return buf, nil
+}
+
+func releaseBody(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBodySize {
+		bodyPool.Put(buf)
+	}
+}
+
+// decodePaymentRequest parses the usual {"correlationId":"...","amount":n}
+// body without reflection. It reports false for anything else, such as escaped
+// or non-ASCII strings, other fields, nested values or numbers outside the
+// JSON grammar, and the caller then falls back to encoding/json so both paths
+// accept the same documents.
+func decodePaymentRequest(data []byte, p *dtos.CreatePaymentRequest) bool {
+	i := skipSpace(data, 0)
+	if i == len(data) || data[i] != '{' {
+		return false
+	}
+
+	for i++; ; i++ {
+		key, next, ok := readString(data, skipSpace(data, i))
+		if !ok {
+			return false
+		}
+
+		i = skipSpace(data, next)
+		if i == len(data) || data[i] != ':' {
+			return false
+		}
+		i = skipSpace(data, i+1)
+
+		switch string(key) {
+		case "correlationId":
+			value, next, ok := readString(data, i)
+			if !ok {
+				return false
+			}
+			p.CorrelationId = string(value)
+			i = next
+		case "amount":
+			end, ok := scanNumber(data, i)
+			if !ok {
+				return false
+			}
+			amount, err := strconv.ParseFloat(string(data[i:end]), 64)
+			if err != nil {
+				return false
+			}
+			p.Amount = amount
+			i = end
+		default:
+			return false
+		}
+
+		i = skipSpace(data, i)
+		if i == len(data) {
+			return false
+		}
+		if data[i] == '}' {
+			return skipSpace(data, i+1) == len(data)
+		}
+		if data[i] != ',' {
+			return false
+		}
+	}
+}
+
+func readString(data []byte, i int) ([]byte, int, bool) {
+	if i == len(data) || data[i] != '"' {
+		return nil, i, false
+	}
+
+	for j := i + 1; j < len(data); j++ {
+		switch c := data[j]; {
+		case c == '"':
+			return data[i+1 : j], j + 1, true
+		case c == '\\' || c < 0x20 || c >= 0x80:
+			return nil, i, false
+		}
+	}
+	return nil, i, false
+}
+
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\n' || data[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+// scanNumber returns the end of the JSON number starting at i, matching
+// -?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)? and nothing looser, since
+// strconv.ParseFloat alone also takes forms such as 01, .5, Inf or 1_0.
+func scanNumber(data []byte, i int) (int, bool) {
+	if i < len(data) && data[i] == '-' {
+		i++
+	}
+
+	switch {
+	case i < len(data) && data[i] == '0':
+		i++
+	case i < len(data) && data[i] >= '1' && data[i] <= '9':
+		i = skipDigits(data, i+1)
+	default:
+		return i, false
+	}
+
+	if i < len(data) && data[i] == '.' {
+		end := skipDigits(data, i+1)
+		if end == i+1 {
+			return i, false
+		}
+		i = end
+	}
+
+	if i < len(data) && (data[i] == 'e' || data[i] == 'E') {
+		i++
+		if i < len(data) && (data[i] == '+' || data[i] == '-') {
+			i++
+		}
+		end := skipDigits(data, i)
+		if end == i {
+			return i, false
+		}
+		i = end
+	}
+
+	return i, true
+}
+
+func skipDigits(data []byte, i int) int {
+	for i < len(data) && data[i] >= '0' && data[i] <= '9' {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"go-service/internal/dtos"
+	"testing"
+)
+
+var decodeCases = []string{
+	`{"correlationId":"4a7901b8-7d26-4d9d-aa19-4dc1c7cf60b3","amount":19.9}`,
+	` { "amount" : 1e2 , "correlationId" : "a" } `,
+	`{"correlationId":"a","amount":0}`,
+	`{"correlationId":"a","amount":-0.5E-3}`,
+	`{"correlationId":"a","amount":10,"amount":20}`,
+	`{"correlationId":"a","amount":1.5e+2}`,
+
+	// Numbers encoding/json rejects but strconv.ParseFloat takes.
+	`{"correlationId":"a","amount":01}`,
+	`{"correlationId":"a","amount":1.}`,
+	`{"correlationId":"a","amount":.5}`,
+	`{"correlationId":"a","amount":Inf}`,
+	`{"correlationId":"a","amount":+Inf}`,
+	`{"correlationId":"a","amount":NaN}`,
+	`{"correlationId":"a","amount":1_0}`,
+	`{"correlationId":"a","amount":+1}`,
+	`{"correlationId":"a","amount":0x10}`,
+	`{"correlationId":"a","amount":1e}`,
+	`{"correlationId":"a","amount":1e+}`,
+	`{"correlationId":"a","amount":-}`,
+	`{"correlationId":"a","amount":--1}`,
+	`{"correlationId":"a","amount":1e400}`,
+
+	// Documents the fast path hands over to encoding/json.
+	`{"correlationId":"ab","amount":1}`,
+	`{"correlationId":"caf` + "\xc3\xa9" + `","amount":1}`,
+	`{"correlationId":"` + "\xff" + `","amount":1}`,
+	`{"CorrelationID":"a","amount":1}`,
+	`{"correlationId":"a","amount":1,"currency":"USD"}`,
+	`{"correlationId":"a","amount":null}`,
+	`{"correlationId":"a","amount":"1"}`,
+	`{}`,
+
+	// Malformed documents.
+	``,
+	`{`,
+	`{"correlationId":"a","amount":1`,
+	`{"correlationId":"a","amount":1,}`,
+	`{"correlationId":"a" "amount":1}`,
+	`{"correlationId":"a","amount":1}}`,
+	`{"correlationId":"a","amount":1} x`,
+	`{"correlationId":"a` + "\n" + `","amount":1}`,
+	`["correlationId","a"]`,
+}
+
+// TestDecodePaymentRequestParity checks that whenever the fast path accepts a
+// document, encoding/json accepts it too and decodes the same values.
+func TestDecodePaymentRequestParity(t *testing.T) {
+	for _, input := range decodeCases {
+		assertDecodeParity(t, []byte(input))
+	}
+}
+
+func FuzzDecodePaymentRequest(f *testing.F) {
+	for _, input := range decodeCases {
+		f.Add([]byte(input))
+	}
+	f.Fuzz(assertDecodeParity)
+}
+
+func assertDecodeParity(t *testing.T, data []byte) {
+	var fast dtos.CreatePaymentRequest
+	if !decodePaymentRequest(data, &fast) {
+		return
+	}
+
+	var std dtos.CreatePaymentRequest
+	if err := json.Unmarshal(data, &std); err != nil {
+		t.Fatalf("fast path accepted %q, encoding/json rejects it: %v", data, err)
+	}
+	if fast != std {
+		t.Fatalf("decoding %q: fast path got %+v, encoding/json got %+v", data, fast, std)
+	}
+}
+
+func TestDecodePaymentRequestRejectsNonJSONNumbers(t *testing.T) {
+	for _, amount := range []string{"01", "1.", ".5", "Inf", "NaN", "1_0", "+1", "0x10", "1e", "-"} {
+		var p dtos.CreatePaymentRequest
+		if decodePaymentRequest([]byte(`{"correlationId":"a","amount":`+amount+`}`), &p) {
+			t.Errorf("fast path accepted amount %s", amount)
+		}
+	}
+}
+
+func BenchmarkDecodePayment(b *testing.B) {
+	data := []byte(`{"correlationId":"4a7901b8-7d26-4d9d-aa19-4dc1c7cf60b3","amount":19.9}`)
+
+	b.Run("fast", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			var p dtos.CreatePaymentRequest
+			if !decodePaymentRequest(data, &p) {
+				b.Fatal("fast path rejected the payment")
+			}
+		}
+	})
+
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			var p dtos.CreatePaymentRequest
+			if err := json.Unmarshal(data, &p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"go-service/internal/config"
+	"go-service/internal/dtos"
+	internalErrors "go-service/internal/errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	exportFlushEvery    = 1000
+	exportWriteDeadline = 10 * time.Second
+)
+
+type exportRow struct {
+	CorrelationId string  `json:"correlationId"`
+	Amount        float64 `json:"amount"`
+	Currency      string  `json:"currency"`
+	Processor     string  `json:"processor"`
+	RequestedAt   string  `json:"requestedAt"`
+	SubmittedAt   string  `json:"submittedAt,omitempty"`
+}
+
+func (s *HttpServer) exportPayments(w http.ResponseWriter, r *http.Request) {
+	filters, err := parseSummaryFilters(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	processor := r.URL.Query().Get("processor")
+	if processor != "" && processor != config.DefaultProcessor && processor != config.FallbackProcessor {
+		http.Error(w, "processor must be default or fallback", http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+
+	var write func(row exportRow) error
+	var flush func() error
+
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(w)
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="payments.csv"`)
+		writer.Write([]string{"correlationId", "amount", "currency", "processor", "requestedAt", "submittedAt"})
+
+		write = func(row exportRow) error {
+			return writer.Write([]string{
+				row.CorrelationId,
+				strconv.FormatFloat(row.Amount, 'f', -1, 64),
+				row.Currency,
+				row.Processor,
+				row.RequestedAt,
+				row.SubmittedAt,
+			})
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	case "ndjson":
+		encoder := json.NewEncoder(w)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="payments.ndjson"`)
+
+		write = func(row exportRow) error {
+			return encoder.Encode(row)
+		}
+		flush = func() error {
+			return nil
+		}
+	default:
+		http.Error(w, "format must be csv or ndjson", http.StatusBadRequest)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(exportWriteDeadline))
+
+	rows := 0
+	err = s.ps.ExportPayments(r.Context(), filters, processor, func(payment *dtos.Payment) error {
+		err := write(exportRow{
+			CorrelationId: payment.CorrelationId,
+			Amount:        payment.Amount,
+			Currency:      normalizeCurrency(payment.Currency),
+			Processor:     payment.Processor,
+			RequestedAt:   formatTime(payment.RequestedAt),
+			SubmittedAt:   formatTime(payment.SubmittedAt),
+		})
+		if err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushEvery != 0 {
+			return nil
+		}
+
+		if err := flush(); err != nil {
+			return err
+		}
+		rc.SetWriteDeadline(time.Now().Add(exportWriteDeadline))
+		return rc.Flush()
+	})
+	if errors.Is(err, internalErrors.ErrExportWindowCompacted) && rows == 0 {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "from must not reach into compacted payments, which can only be summarized", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		// Headers are already on the wire, so all we can do is cut the stream short.
+		slog.Error("error while exporting payments", "rows", rows, "error", err)
+		return
+	}
+
+	flush()
+}
@@ -1,12 +1,21 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"go-service/internal/config"
 	"go-service/internal/dtos"
+	"go-service/internal/entities"
+	internalErrors "go-service/internal/errors"
+	"go-service/internal/merchants"
+	"go-service/internal/webhooks"
 	"io"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -16,72 +25,448 @@ type GetPaymentsSummaryFilters struct {
 }
 
 func (s *HttpServer) paymentsSummary(w http.ResponseWriter, r *http.Request) {
-	from := r.URL.Query().Get("from")
-	to := r.URL.Query().Get("to")
-
-	filters := dtos.GetPaymentsSummaryFilters{}
-
-	if from != "" {
-		fromDate, err := time.Parse(config.DateTimeFormat, from)
-		if err == nil {
-			filters.From = fromDate
-		} else {
-			slog.Error("failed to parse from date", "date", from, "error", err)
-		}
+	filters, err := parseSummaryFilters(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if to != "" {
-		toDate, err := time.Parse(config.DateTimeFormat, to)
-		if err == nil {
-			filters.To = toDate
-		} else {
-			slog.Error("failed to parse to date", "date", to, "error", err)
-		}
+	opts, err := parseSummaryOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	summary, err := s.ps.GetSummary(filters)
+	summary, err := s.ps.GetSummary(filters, opts)
+	if errors.Is(err, internalErrors.ErrSummaryWindowMisaligned) {
+		http.Error(w, "from and to must fall on compaction bucket boundaries for compacted payments", http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
 		slog.Error("error while fetching payments summary", "error", err)
 		http.Error(w, "Error while fetching payments summary", http.StatusInternalServerError)
 		return
 	}
 
+	opts.Currencies = opts.Currencies || hasForeignCurrency(summary.Default) || hasForeignCurrency(summary.Fallback)
+
 	summaryResponse := dtos.GetPaymentSummaryResponse{
-		Default:  dtos.PaymentSummary(summary.Default),
-		Fallback: dtos.PaymentSummary(summary.Fallback),
+		Default:  paymentSummaryResponse(summary.Default, opts),
+		Fallback: paymentSummaryResponse(summary.Fallback, opts),
+	}
+
+	for _, bucket := range summary.Buckets {
+		summaryResponse.Buckets = append(summaryResponse.Buckets, dtos.SummaryBucket{
+			Start:    formatTime(bucket.Start),
+			Default:  paymentSummaryResponse(bucket.Default, opts),
+			Fallback: paymentSummaryResponse(bucket.Fallback, opts),
+		})
 	}
 
 	writeJSON(w, http.StatusOK, summaryResponse)
 }
 
+// parseSummaryOptions reads the optional groupBy=minute|hour and
+// include=fees,latency parameters. With neither, the response keeps the
+// original totalRequests/totalAmount shape.
+func parseSummaryOptions(r *http.Request) (dtos.SummaryOptions, error) {
+	query := r.URL.Query()
+	opts := dtos.SummaryOptions{}
+
+	switch query.Get("groupBy") {
+	case "":
+	case "minute":
+		opts.GroupBy = time.Minute
+	case "hour":
+		opts.GroupBy = time.Hour
+	default:
+		return opts, errors.New("groupBy must be minute or hour")
+	}
+
+	if include := query.Get("include"); include != "" {
+		for _, part := range strings.Split(include, ",") {
+			switch strings.TrimSpace(part) {
+			case "fees":
+				opts.Fees = true
+			case "latency":
+				opts.Latency = true
+			case "currencies":
+				opts.Currencies = true
+			default:
+				return opts, fmt.Errorf("unknown include %q: expected fees, latency or currencies", part)
+			}
+		}
+	}
+
+	return opts, nil
+}
+
+func hasForeignCurrency(stats entities.PaymentStats) bool {
+	for currency := range stats.ByCurrency {
+		if currency != config.DefaultCurrency {
+			return true
+		}
+	}
+	return false
+}
+
+func paymentSummaryResponse(stats entities.PaymentStats, opts dtos.SummaryOptions) dtos.PaymentSummary {
+	summary := dtos.PaymentSummary{
+		TotalRequests: stats.TotalRequests,
+		TotalAmount:   stats.TotalAmount,
+	}
+
+	if opts.Fees {
+		summary.TotalFee = &stats.TotalFee
+		summary.NetAmount = &stats.NetAmount
+	}
+
+	if opts.Currencies {
+		summary.ByCurrency = make(map[string]dtos.CurrencySummary, len(stats.ByCurrency))
+		for currency, totals := range stats.ByCurrency {
+			currencySummary := dtos.CurrencySummary{
+				TotalRequests: totals.TotalRequests,
+				TotalAmount:   totals.TotalAmount,
+			}
+			if opts.Fees {
+				currencySummary.TotalFee = &totals.TotalFee
+				currencySummary.NetAmount = &totals.NetAmount
+			}
+			summary.ByCurrency[currency] = currencySummary
+		}
+	}
+
+	if opts.Latency && stats.Latency != nil {
+		summary.Latency = &dtos.LatencyPercentiles{
+			P50: stats.Latency.P50.Milliseconds(),
+			P90: stats.Latency.P90.Milliseconds(),
+			P95: stats.Latency.P95.Milliseconds(),
+			P99: stats.Latency.P99.Milliseconds(),
+			Max: stats.Latency.Max.Milliseconds(),
+		}
+	}
+
+	return summary
+}
+
+// parseSummaryFilters accepts RFC 3339 timestamps with any fractional
+// precision and offset. The bounds parameter picks the window semantics:
+// inclusive (the default) is [from, to], exclusive is (from, to) and
+// half-open is [from, to). timeField=submittedAt applies the window to when
+// payments were submitted to a processor instead of when they were requested.
+func parseSummaryFilters(r *http.Request) (dtos.GetPaymentsSummaryFilters, error) {
+	query := r.URL.Query()
+	filters := dtos.GetPaymentsSummaryFilters{
+		MerchantId: merchants.FromContext(r.Context()).Id,
+	}
+
+	if from := query.Get("from"); from != "" {
+		fromDate, err := time.Parse(time.RFC3339Nano, from)
+		if err != nil {
+			return filters, fmt.Errorf("invalid from date %q: expected RFC 3339", from)
+		}
+		filters.From = fromDate
+	}
+
+	if to := query.Get("to"); to != "" {
+		toDate, err := time.Parse(time.RFC3339Nano, to)
+		if err != nil {
+			return filters, fmt.Errorf("invalid to date %q: expected RFC 3339", to)
+		}
+		filters.To = toDate
+	}
+
+	if !filters.From.IsZero() && !filters.To.IsZero() && filters.From.After(filters.To) {
+		return filters, errors.New("from must not be after to")
+	}
+
+	switch query.Get("bounds") {
+	case "", "inclusive":
+	case "exclusive":
+		filters.FromExclusive = true
+		filters.ToExclusive = true
+	case "half-open":
+		filters.ToExclusive = true
+	default:
+		return filters, errors.New("bounds must be inclusive, exclusive or half-open")
+	}
+
+	switch timeField := query.Get("timeField"); timeField {
+	case "", dtos.TimeFieldRequested:
+		filters.TimeField = dtos.TimeFieldRequested
+	case dtos.TimeFieldSubmitted:
+		filters.TimeField = timeField
+	default:
+		return filters, fmt.Errorf("timeField must be %s or %s", dtos.TimeFieldRequested, dtos.TimeFieldSubmitted)
+	}
+
+	return filters, nil
+}
+
+var emptyObject = []byte("{}")
+
 func (s *HttpServer) createPayment(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	body, err := io.ReadAll(r.Body)
+	body, err := readBody(r.Body)
 	if err != nil {
 		slog.Error("cannot read request body", "error", err)
 		http.Error(w, "Cannot read request body", http.StatusUnprocessableEntity)
 		return
 	}
+	defer releaseBody(body)
 
-	defer r.Body.Close()
+	var payment dtos.CreatePaymentRequest
+	if !decodePaymentRequest(body.Bytes(), &payment) {
+		payment = dtos.CreatePaymentRequest{}
+		if err := json.Unmarshal(body.Bytes(), &payment); err != nil {
+			slog.Error("cannot unmarshal request body", "error", err)
+			http.Error(w, "Cannot unmarshal request body", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	payment.Currency = normalizeCurrency(payment.Currency)
+	if err := validatePaymentRequest(payment, s.cfg.Currencies, s.ps.WebhooksEnabled()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	payment.MerchantId = merchants.FromContext(r.Context()).Id
+
+	if !s.offerIntake(payment) {
+		s.requestProcessing(payment)
+	}
 
+	w.WriteHeader(http.StatusOK)
+	w.Write(emptyObject)
+}
+
+// offerIntake hands the payment to the intake workers unless the intake is
+// full, which means the queue is falling behind, or already closed by
+// Shutdown. Either way the caller enqueues it itself.
+func (s *HttpServer) offerIntake(payment dtos.CreatePaymentRequest) bool {
+	s.intakeMu.RLock()
+	defer s.intakeMu.RUnlock()
+	if s.intakeClosed {
+		return false
+	}
+
+	select {
+	case s.intake <- payment:
+		return true
+	default:
+		return false
+	}
+}
+
+// runIntake enqueues payments until the intake is closed and empty, or until
+// Shutdown runs out of time and takes over what is left.
+func (s *HttpServer) runIntake() {
+	defer s.intakeWg.Done()
+	for {
+		select {
+		case <-s.intakeAbort:
+			return
+		case payment, ok := <-s.intake:
+			if !ok {
+				return
+			}
+			s.requestProcessing(payment)
+		}
+	}
+}
+
+func (s *HttpServer) requestProcessing(payment dtos.CreatePaymentRequest) {
+	if err := s.ps.RequestProcessing(payment); err != nil {
+		slog.Error("error requesting payment processing", "correlationId", payment.CorrelationId, "error", err)
+	}
+}
+
+// batchMaxLineBytes bounds a single NDJSON line of a batch; a longer line is
+// rejected by itself.
+const batchMaxLineBytes = 64 << 10
+
+// createPaymentsBatch reads and validates the whole body before enqueuing
+// anything, so a body that turns out too large or unreadable is rejected
+// without any of its payments having been queued.
+func (s *HttpServer) createPaymentsBatch(w http.ResponseWriter, r *http.Request) {
+	body := http.MaxBytesReader(w, r.Body, s.cfg.BatchMaxBytes)
+	defer body.Close()
+
+	response := dtos.CreatePaymentsBatchResponse{
+		Accepted: []int{},
+		Rejected: []dtos.BatchRejection{},
+	}
+
+	merchantId := merchants.FromContext(r.Context()).Id
+	var payments []dtos.CreatePaymentRequest
+	var indexes []int
+
+	reader := bufio.NewReaderSize(body, batchMaxLineBytes)
+
+	var readErr error
+	for index := 0; ; index++ {
+		line, err := reader.ReadSlice('\n')
+
+		// A line that does not fit the buffer is rejected on its own and the
+		// rest of it skipped, so the lines after it are still read.
+		tooLong := false
+		for err == bufio.ErrBufferFull {
+			tooLong = true
+			_, err = reader.ReadSlice('\n')
+		}
+		if err != nil && err != io.EOF {
+			readErr = err
+			break
+		}
+
+		if tooLong {
+			response.Rejected = append(response.Rejected, dtos.BatchRejection{Index: index, Error: "Line exceeds the maximum line size"})
+		} else if line = bytes.TrimSpace(line); len(line) > 0 {
+			payment, err := s.parseBatchLine(line)
+			if err != nil {
+				response.Rejected = append(response.Rejected, dtos.BatchRejection{Index: index, Error: err.Error()})
+			} else {
+				payment.MerchantId = merchantId
+				payments = append(payments, payment)
+				indexes = append(indexes, index)
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if readErr != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(readErr, &maxBytesErr) {
+			http.Error(w, "Batch exceeds the maximum payload size", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		slog.Error("cannot read batch body", "error", readErr)
+		http.Error(w, "Cannot read request body", http.StatusUnprocessableEntity)
+		return
+	}
+
+	for start := 0; start < len(payments); start += s.cfg.BatchChunkSize {
+		end := min(start+s.cfg.BatchChunkSize, len(payments))
+		if err := s.ps.RequestProcessingBatch(r.Context(), payments[start:end]); err != nil {
+			slog.Error("error enqueuing payment batch chunk", "size", end-start, "error", err)
+			for _, index := range indexes[start:end] {
+				response.Rejected = append(response.Rejected, dtos.BatchRejection{Index: index, Error: "Cannot enqueue payment"})
+			}
+			continue
+		}
+		response.Accepted = append(response.Accepted, indexes[start:end]...)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *HttpServer) parseBatchLine(line []byte) (dtos.CreatePaymentRequest, error) {
 	var payment dtos.CreatePaymentRequest
-	err = json.Unmarshal(body, &payment)
+	if err := json.Unmarshal(line, &payment); err != nil {
+		return payment, errors.New("Cannot unmarshal line")
+	}
+
+	payment.Currency = normalizeCurrency(payment.Currency)
+	if err := validatePaymentRequest(payment, s.cfg.Currencies, s.ps.WebhooksEnabled()); err != nil {
+		return payment, err
+	}
+	return payment, nil
+}
+
+func (s *HttpServer) paymentStatus(w http.ResponseWriter, r *http.Request) {
+	correlationId := r.PathValue("correlationId")
+
+	status, err := s.ps.GetStatus(r.Context(), merchants.FromContext(r.Context()).Id, correlationId)
+	if errors.Is(err, internalErrors.ErrPaymentNotFound) {
+		http.Error(w, "Payment not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		slog.Error("cannot unmarshal request body", "error", err)
+		slog.Error("error while fetching payment status", "correlationId", correlationId, "error", err)
+		http.Error(w, "Error while fetching payment status", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, dtos.PaymentStatusResponse{
+		CorrelationId: status.CorrelationId,
+		State:         status.State,
+		Amount:        status.Amount,
+		Currency:      status.Currency,
+		RequestedAt:   formatTime(status.RequestedAt),
+		SubmittedAt:   formatTime(status.SubmittedAt),
+		RetryCount:    status.RetryCount,
+		NextAttemptAt: formatTime(status.NextAttemptAt),
+		Processor:     status.Processor,
+		ProcessedAt:   formatTime(status.ProcessedAt),
+		Error:         status.Error,
+	})
+}
+
+func (s *HttpServer) registerWebhook(w http.ResponseWriter, r *http.Request) {
+	var request dtos.RegisterWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, "Cannot unmarshal request body", http.StatusUnprocessableEntity)
 		return
 	}
 
-	go func() {
-		err = s.ps.RequestProcessing(payment.CorrelationId, payment.Amount)
-		if err != nil {
-			slog.Error("error requesting payment processing", "error", err)
-		}
-	}()
+	err := s.ps.RegisterWebhook(r.Context(), merchants.FromContext(r.Context()).Id, request.Url)
+	if errors.Is(err, webhooks.ErrDisabled) {
+		http.Error(w, "Webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, webhooks.ErrInvalidCallbackUrl) {
+		http.Error(w, "Invalid webhook url", http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		slog.Error("error while registering webhook", "url", request.Url, "error", err)
+		http.Error(w, "Error while registering webhook", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, request)
+}
+
+func (s *HttpServer) unregisterWebhook(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		http.Error(w, "Missing url parameter", http.StatusBadRequest)
+		return
+	}
+
+	err := s.ps.UnregisterWebhook(r.Context(), merchants.FromContext(r.Context()).Id, url)
+	if errors.Is(err, webhooks.ErrDisabled) {
+		http.Error(w, "Webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("error while unregistering webhook", "url", url, "error", err)
+		http.Error(w, "Error while unregistering webhook", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *HttpServer) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	urls, err := s.ps.Webhooks(r.Context(), merchants.FromContext(r.Context()).Id)
+	if errors.Is(err, webhooks.ErrDisabled) {
+		http.Error(w, "Webhooks are disabled", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("error while listing webhooks", "error", err)
+		http.Error(w, "Error while listing webhooks", http.StatusInternalServerError)
+		return
+	}
 
-	writeJSON(w, http.StatusOK, struct{}{})
+	writeJSON(w, http.StatusOK, dtos.WebhooksResponse{Urls: urls})
 }
 
 func (s *HttpServer) healthCheck(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"go-service/internal/config"
+	"go-service/internal/dtos"
+	"go-service/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type batchRecorder struct {
+	services.PaymentsInterface
+	enqueued []dtos.CreatePaymentRequest
+}
+
+func (b *batchRecorder) WebhooksEnabled() bool { return false }
+
+func (b *batchRecorder) RequestProcessingBatch(_ context.Context, requests []dtos.CreatePaymentRequest) error {
+	b.enqueued = append(b.enqueued, requests...)
+	return nil
+}
+
+func TestCreatePaymentsBatchRejectsOverlongLinesByIndex(t *testing.T) {
+	ps := &batchRecorder{}
+	s := &HttpServer{ps: ps, cfg: config.ServerConfig{
+		BatchMaxBytes:  1 << 20,
+		BatchChunkSize: 10,
+		Currencies:     []string{config.DefaultCurrency},
+	}}
+
+	body := strings.Join([]string{
+		`{"correlationId":"a","amount":1,"currency":"BRL"}`,
+		`{"correlationId":"` + strings.Repeat("x", 2*batchMaxLineBytes) + `","amount":1,"currency":"BRL"}`,
+		``,
+		`{"correlationId":"b","amount":-1,"currency":"BRL"}`,
+		`{"correlationId":"c","amount":2,"currency":"BRL"}`,
+	}, "\n")
+
+	w := httptest.NewRecorder()
+	s.createPaymentsBatch(w, httptest.NewRequest(http.MethodPost, "/payments/batch", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var response dtos.CreatePaymentsBatchResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(response.Accepted, []int{0, 4}) {
+		t.Errorf("accepted = %v, want [0 4]", response.Accepted)
+	}
+
+	rejected := make([]int, len(response.Rejected))
+	for i, r := range response.Rejected {
+		rejected[i] = r.Index
+	}
+	if !reflect.DeepEqual(rejected, []int{1, 3}) {
+		t.Errorf("rejected = %v, want [1 3]", response.Rejected)
+	}
+	if len(ps.enqueued) != 2 {
+		t.Errorf("enqueued %d payments, want 2", len(ps.enqueued))
+	}
+}
@@ -2,7 +2,9 @@ package server
 
 import (
 	"encoding/json"
+	"go-service/internal/config"
 	"net/http"
+	"time"
 )
 
 func writeJSON(w http.ResponseWriter, status int, data interface{}) error {
@@ -17,3 +19,10 @@ func writeJSON(w http.ResponseWriter, status int, data interface{}) error {
 
 	return nil
 }
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(config.DateTimeFormat)
+}
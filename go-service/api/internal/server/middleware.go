@@ -1,7 +1,10 @@
 package server
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"go-service/internal/merchants"
 	"log/slog"
 	"net/http"
 )
@@ -44,3 +47,74 @@ func (s *HttpServer) noCache(next http.HandlerFunc) http.HandlerFunc {
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (s *HttpServer) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		merchant, err := s.merchants.Authenticate(r.Header.Get(merchants.ApiKeyHeader))
+		if errors.Is(err, merchants.ErrUnknownApiKey) {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(merchants.WithMerchant(r.Context(), merchant)))
+	})
+}
+
+// requireApiKey turns away callers without an API key once merchants are
+// configured, since they would otherwise act on the default merchant's
+// behalf; there the default merchant is reached with the admin key. Without
+// merchants everyone is the default merchant and no key is needed.
+func (s *HttpServer) requireApiKey(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.merchants.MultiTenant() || r.Header.Get(merchants.ApiKeyHeader) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.Header.Get(merchants.AdminKeyHeader)
+		if s.cfg.AdminApiKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(s.cfg.AdminApiKey)) != 1 {
+			http.Error(w, "API key required", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdmin guards operations that span every merchant. Without merchants
+// there is only the anonymous caller's data, so the key is only needed once
+// ADMIN_API_KEY is set or merchants are configured; with merchants and no
+// ADMIN_API_KEY these operations are refused.
+func (s *HttpServer) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.AdminApiKey == "" && !s.merchants.MultiTenant() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := r.Header.Get(merchants.AdminKeyHeader)
+		if s.cfg.AdminApiKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(s.cfg.AdminApiKey)) != 1 {
+			http.Error(w, "Admin API key required", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *HttpServer) rateLimitMerchant(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, err := merchants.FromContext(r.Context()).Allow(r.Context())
+		if err != nil {
+			slog.Error("cannot check merchant rate limit", "error", err)
+		}
+
+		if err == nil && !allowed {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"go-service/internal/config"
+	"go-service/internal/merchants"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireApiKey(t *testing.T) {
+	single, err := merchants.NewRegistry(config.MerchantsConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multi, err := merchants.NewRegistry(config.MerchantsConfig{
+		Merchants: []config.MerchantConfig{{Id: "acme", ApiKey: "acme-key"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		registry *merchants.Registry
+		adminKey string
+		headers  map[string]string
+		want     int
+	}{
+		{"single tenant without key", single, "", nil, http.StatusOK},
+		{"multi tenant without key", multi, "", nil, http.StatusUnauthorized},
+		{"multi tenant with merchant key", multi, "", map[string]string{merchants.ApiKeyHeader: "acme-key"}, http.StatusOK},
+		{"multi tenant with admin key", multi, "admin", map[string]string{merchants.AdminKeyHeader: "admin"}, http.StatusOK},
+		{"multi tenant with wrong admin key", multi, "admin", map[string]string{merchants.AdminKeyHeader: "nope"}, http.StatusUnauthorized},
+		{"multi tenant with admin key unset", multi, "", map[string]string{merchants.AdminKeyHeader: ""}, http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &HttpServer{merchants: c.registry, cfg: config.ServerConfig{AdminApiKey: c.adminKey}}
+			handler := s.requireApiKey(func(w http.ResponseWriter, r *http.Request) {})
+
+			r := httptest.NewRequest(http.MethodGet, "/webhooks", nil)
+			for k, v := range c.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != c.want {
+				t.Fatalf("status = %d, want %d", w.Code, c.want)
+			}
+		})
+	}
+}
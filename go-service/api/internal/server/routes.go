@@ -1,14 +1,23 @@
 package server
 
 import (
+	"go-service/internal/metrics"
 	"net/http"
 )
 
 func (s *HttpServer) loadRoutes(mux *http.ServeMux) http.HandlerFunc {
 	mux.HandleFunc("GET /payments-summary", s.paymentsSummary)
-	mux.HandleFunc("POST /purge-payments", s.purgePayments)
-	mux.HandleFunc("POST /payments", s.createPayment)
+	mux.HandleFunc("POST /purge-payments", s.requireAdmin(s.purgePayments))
+	mux.HandleFunc("POST /payments", s.rateLimitMerchant(s.createPayment))
+	mux.HandleFunc("POST /payments/batch", s.rateLimitMerchant(s.createPaymentsBatch))
+	mux.HandleFunc("GET /payments/stream", s.paymentsStream)
+	mux.HandleFunc("GET /payments/export", s.exportPayments)
+	mux.HandleFunc("GET /payments/{correlationId}", s.paymentStatus)
+	mux.HandleFunc("POST /webhooks", s.requireApiKey(s.registerWebhook))
+	mux.HandleFunc("DELETE /webhooks", s.requireApiKey(s.unregisterWebhook))
+	mux.HandleFunc("GET /webhooks", s.requireApiKey(s.listWebhooks))
 	mux.HandleFunc("GET /healthcheck", s.healthCheck)
+	mux.HandleFunc("GET /metrics", metrics.Handler(metrics.Default))
 
 	return mux.ServeHTTP
 }
@@ -2,53 +2,174 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"go-service/internal/config"
+	"go-service/internal/dtos"
+	"go-service/internal/merchants"
 	"go-service/internal/services"
+	"log/slog"
+	"net"
 	"net/http"
-	"strconv"
+	"os"
+	"sync"
 	"time"
 )
 
 type HttpServer struct {
-	ps     services.PaymentsInterface
-	port   string
-	server *http.Server
+	ps        services.PaymentsInterface
+	server    *http.Server
+	cfg       config.ServerConfig
+	merchants *merchants.Registry
+
+	intake       chan dtos.CreatePaymentRequest
+	intakeWg     sync.WaitGroup
+	intakeMu     sync.RWMutex
+	intakeClosed bool
+	intakeAbort  chan struct{}
+
+	// done is closed as soon as shutdown starts, so long-lived streams end
+	// instead of holding Shutdown until its deadline.
+	done chan struct{}
 }
 
-func NewServer(port string, ps services.PaymentsInterface) *HttpServer {
-	return &HttpServer{
-		ps:   ps,
-		port: port,
+func NewServer(ps services.PaymentsInterface, cfg config.ServerConfig, merchants *merchants.Registry) *HttpServer {
+	s := &HttpServer{
+		ps:          ps,
+		cfg:         cfg,
+		merchants:   merchants,
+		intake:      make(chan dtos.CreatePaymentRequest, max(cfg.IntakeBuffer, 1)),
+		intakeAbort: make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	s.server = s.createHTTPServer()
+	s.server.RegisterOnShutdown(func() { close(s.done) })
+
+	for range max(cfg.IntakeWorkers, 1) {
+		s.intakeWg.Add(1)
+		go s.runIntake()
 	}
+
+	return s
 }
 
+// ListenAndServe serves on the configured TCP address, Unix socket, or both,
+// and returns once any listener fails or the server is shut down.
 func (s *HttpServer) ListenAndServe() error {
-	portNum, err := strconv.Atoi(s.port)
+	var listeners []net.Listener
+
+	if s.cfg.Addr != "" {
+		l, err := net.Listen("tcp", s.cfg.Addr)
+		if err != nil {
+			return fmt.Errorf("listening on %s: %w", s.cfg.Addr, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	if s.cfg.Socket != "" {
+		l, err := listenUnix(s.cfg.Socket, s.cfg.SocketMode)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			errs <- s.server.Serve(l)
+		}()
+	}
+
+	return <-errs
+}
+
+// listenUnix removes a socket file left behind by a previous process, but
+// refuses to take over one that still accepts connections.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale socket %s: %w", path, err)
+		}
+	}
+
+	l, err := net.Listen("unix", path)
 	if err != nil {
-		portNum = 8080
+		return nil, fmt.Errorf("listening on %s: %w", path, err)
 	}
 
-	s.server = s.createHTTPServer(portNum)
-	return s.server.ListenAndServe()
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("setting permissions on %s: %w", path, err)
+	}
+
+	return l, nil
 }
 
+// Shutdown stops accepting requests, then waits for payments that were
+// already acknowledged to reach the queue. The intake is drained even when
+// the HTTP shutdown runs out of time: whatever is still buffered once ctx
+// expires is spilled to the write-ahead log instead of being dropped.
 func (s *HttpServer) Shutdown(ctx context.Context) error {
-	if s.server != nil {
-		return s.server.Shutdown(ctx)
+	httpErr := s.server.Shutdown(ctx)
+
+	s.intakeMu.Lock()
+	s.intakeClosed = true
+	close(s.intake)
+	s.intakeMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.intakeWg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return httpErr
+	case <-ctx.Done():
+	}
+
+	close(s.intakeAbort)
+	<-drained
+
+	var left []dtos.CreatePaymentRequest
+	for payment := range s.intake {
+		left = append(left, payment)
+	}
+	if len(left) == 0 {
+		return httpErr
+	}
+
+	if err := s.ps.Spill(left); err != nil {
+		return errors.Join(httpErr, fmt.Errorf("spilling %d payments left in intake: %w", len(left), err))
 	}
-	return nil
+	slog.Warn("spilled payments left in intake", "count", len(left))
+	return httpErr
 }
 
-func (s *HttpServer) createHTTPServer(port int) *http.Server {
+func (s *HttpServer) createHTTPServer() *http.Server {
 	router := s.loadRoutes(http.NewServeMux())
 	middlewareChain := NewChain(
+		s.authenticate,
 		s.recoverPanic,
 		s.noCache,
 		s.enableCors,
 	)
 
 	return &http.Server{
-		Addr:         fmt.Sprintf(":%d", port),
 		Handler:      middlewareChain(router),
 		IdleTimeout:  10 * time.Second,
 		ReadTimeout:  2 * time.Second,
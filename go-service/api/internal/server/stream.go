@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"go-service/internal/merchants"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+func (s *HttpServer) paymentsStream(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	// The server-wide WriteTimeout would otherwise cut every stream after a few seconds.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		slog.Error("cannot clear write deadline for stream", "error", err)
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := s.ps.SubscribeEvents(merchants.FromContext(r.Context()).Id)
+	defer s.ps.UnsubscribeEvents(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	heartbeat := time.NewTicker(s.cfg.Stream.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-sub.Dropped:
+			fmt.Fprint(w, "event: dropped\ndata: {\"reason\":\"slow consumer\"}\n\n")
+			rc.Flush()
+			return
+		case msg := <-sub.C:
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Event, msg.Data); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"go-service/internal/config"
+	"go-service/internal/dtos"
+	"go-service/internal/webhooks"
+	"math"
+	"slices"
+	"strings"
+)
+
+func normalizeCurrency(currency string) string {
+	if currency == "" {
+		return config.DefaultCurrency
+	}
+	return strings.ToUpper(currency)
+}
+
+func validatePaymentRequest(payment dtos.CreatePaymentRequest, currencies []string, callbacks bool) error {
+	if payment.CorrelationId == "" {
+		return errors.New("correlationId is required")
+	}
+
+	if payment.Amount <= 0 || math.IsInf(payment.Amount, 0) || math.IsNaN(payment.Amount) {
+		return errors.New("amount must be a positive number")
+	}
+
+	if !slices.Contains(currencies, payment.Currency) {
+		return fmt.Errorf("currency must be one of %s", strings.Join(currencies, ", "))
+	}
+
+	if payment.CallbackUrl != "" {
+		if !callbacks {
+			return errors.New("callbackUrl is not supported while webhooks are disabled")
+		}
+		if err := webhooks.ValidateUrl(payment.CallbackUrl); err != nil {
+			return errors.New("invalid callbackUrl")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"go-service/internal/config"
+	"go-service/internal/metrics"
+	"go-service/internal/queue"
+	"log/slog"
+	"time"
+)
+
+var (
+	compactionRuns      = metrics.NewCounter("compaction_runs_total", "Compaction passes over processed payments.")
+	compactionErrors    = metrics.NewCounter("compaction_errors_total", "Compaction passes that stopped on an error.")
+	compactedPayments   = metrics.NewCounter("compaction_payments_total", "Processed payments rolled up and deleted.")
+	trimmedIds          = metrics.NewCounter("compaction_trimmed_ids_total", "Compacted correlationIds dropped past the dedupe retention.")
+	compactionCutoff    = metrics.NewGauge("compaction_cutoff_timestamp_seconds", "Requested time before which the last pass rolled up processed payments.")
+	compactionDuration  = metrics.NewGauge("compaction_last_duration_seconds", "How long the last compaction pass took.")
+	compactionCompleted = metrics.NewGauge("compaction_last_success_timestamp_seconds", "When a compaction pass last finished without errors.")
+)
+
+// Compactor periodically rolls processed payments older than the retention
+// into aggregates. Every replica may run one; the queue makes sure a payment
+// is only ever rolled up once.
+type Compactor struct {
+	cfg config.CompactionConfig
+	q   queue.PaymentQueueInterface
+}
+
+func NewCompactor(cfg config.CompactionConfig, q queue.PaymentQueueInterface) *Compactor {
+	return &Compactor{cfg: cfg, q: q}
+}
+
+func (c *Compactor) Run(ctx context.Context) {
+	if c.cfg.Retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.compact(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// compact truncates the cutoff to the bucket size so only whole buckets are
+// rolled up, then works through them a batch at a time.
+func (c *Compactor) compact(ctx context.Context) {
+	start := time.Now()
+	cutoff := start.Add(-c.cfg.Retention).Truncate(c.cfg.BucketSize)
+	compactionRuns.Inc()
+	compactionCutoff.Set(float64(cutoff.Unix()))
+
+	for ctx.Err() == nil {
+		n, err := c.q.Compact(ctx, cutoff, c.cfg.BucketSize, c.cfg.BatchSize)
+		if err != nil {
+			compactionErrors.Inc()
+			slog.Error("failed to compact processed payments", "error", err)
+			return
+		}
+
+		compactedPayments.Add(int64(n))
+		if n == 0 {
+			break
+		}
+	}
+
+	trimmed, err := c.q.TrimCompacted(ctx, start.Add(-c.cfg.DedupeRetention))
+	if err != nil {
+		compactionErrors.Inc()
+		slog.Error("failed to trim compacted correlationIds", "error", err)
+		return
+	}
+	trimmedIds.Add(int64(trimmed))
+
+	compactionDuration.Set(time.Since(start).Seconds())
+	if ctx.Err() == nil {
+		compactionCompleted.Set(float64(time.Now().Unix()))
+	}
+}
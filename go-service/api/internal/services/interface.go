@@ -1,18 +1,31 @@
 package services
 
 import (
+	"context"
 	"go-service/internal/dtos"
 	"go-service/internal/entities"
+	"go-service/internal/events"
 )
 
 type PaymentsInterface interface {
-	RequestProcessing(correlationId string, amount float64) error
-	GetSummary(filters dtos.GetPaymentsSummaryFilters) (*entities.PaymentsSummary, error)
-	Process() error
+	RequestProcessing(request dtos.CreatePaymentRequest) error
+	RequestProcessingBatch(ctx context.Context, requests []dtos.CreatePaymentRequest) error
+	Spill(requests []dtos.CreatePaymentRequest) error
+	GetSummary(filters dtos.GetPaymentsSummaryFilters, opts dtos.SummaryOptions) (*entities.PaymentsSummary, error)
+	ExportPayments(ctx context.Context, filters dtos.GetPaymentsSummaryFilters, processor string, fn func(*dtos.Payment) error) error
+	GetStatus(ctx context.Context, merchantId, correlationId string) (*entities.PaymentStatus, error)
+	Process(ctx context.Context) error
+	SubscribeEvents(merchantId string) *events.Subscription
+	UnsubscribeEvents(sub *events.Subscription)
+	WebhooksEnabled() bool
+	RegisterWebhook(ctx context.Context, merchantId, url string) error
+	UnregisterWebhook(ctx context.Context, merchantId, url string) error
+	Webhooks(ctx context.Context, merchantId string) ([]string, error)
 	Clear() error
 }
 
 type ProcessorManagerInterface interface {
-	Dispatch(payment *dtos.Payment) (string, error)
+	Dispatch(ctx context.Context, payment *dtos.Payment) (DispatchResult, error)
+	Status(name string) (ProcessorStatus, bool)
 	Clear() error
 }
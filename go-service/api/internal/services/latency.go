@@ -0,0 +1,41 @@
+package services
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const latencyWindow = 256
+
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples [latencyWindow]time.Duration
+	next    int
+	count   int
+}
+
+func (lt *latencyTracker) Observe(d time.Duration) {
+	lt.mu.Lock()
+	lt.samples[lt.next] = d
+	lt.next = (lt.next + 1) % latencyWindow
+	if lt.count < latencyWindow {
+		lt.count++
+	}
+	lt.mu.Unlock()
+}
+
+func (lt *latencyTracker) Percentile(p float64) (time.Duration, bool) {
+	lt.mu.Lock()
+	if lt.count == 0 {
+		lt.mu.Unlock()
+		return 0, false
+	}
+	sorted := make([]time.Duration, lt.count)
+	copy(sorted, lt.samples[:lt.count])
+	lt.mu.Unlock()
+
+	slices.Sort(sorted)
+	idx := int(p * float64(len(sorted)-1))
+	return sorted[idx], true
+}
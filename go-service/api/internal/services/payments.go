@@ -7,68 +7,276 @@ import (
 	"go-service/internal/dtos"
 	"go-service/internal/entities"
 	internalErrors "go-service/internal/errors"
+	"go-service/internal/events"
 	"go-service/internal/queue"
+	"go-service/internal/wal"
+	"go-service/internal/webhooks"
 	"log/slog"
+	"sync"
 	"time"
 )
 
+const (
+	exportBatchSize     = 1000
+	summaryBatchSize    = 10000
+	summaryReadAttempts = 5
+)
+
+var errSummaryCompacted = errors.New("payments were compacted while reading the summary")
+
 type PaymentService struct {
-	pm ProcessorManagerInterface
-	q  queue.PaymentQueueInterface
+	cfg config.PaymentConfig
+	pm  ProcessorManagerInterface
+	q   queue.PaymentQueueInterface
+	wh  webhooks.NotifierInterface
+	hub *events.Hub
+	wal *wal.Log
+
+	pool    *WorkerPool
+	latency latencyTracker
+	summary *summaryCache
+	retry   RetryPolicy
 }
 
 func NewPaymentService(
+	cfg config.PaymentConfig,
 	pm ProcessorManagerInterface,
 	q queue.PaymentQueueInterface,
+	wh webhooks.NotifierInterface,
+	hub *events.Hub,
+	log *wal.Log,
 ) *PaymentService {
-	return &PaymentService{
-		pm: pm,
-		q:  q,
+	ps := &PaymentService{
+		cfg:     cfg,
+		pm:      pm,
+		q:       q,
+		wh:      wh,
+		hub:     hub,
+		wal:     log,
+		summary: newSummaryCache(cfg.SummaryCacheTTL),
+		retry:   NewRetryPolicy(cfg.Retry),
 	}
-}
 
-func (ps *PaymentService) StartWorker(ctx context.Context) {
-	numWorkers := 3
-	for i := 0; i < numWorkers; i++ {
-		go ps.worker(ctx, i)
-	}
+	hub.OnProcessed(func(p events.ProcessedPayment) {
+		requestedAt, err := time.Parse(config.DateTimeFormat, p.RequestedAt)
+		if err != nil {
+			slog.Error("cannot parse requestedAt of processed payment event", "correlationId", p.CorrelationId, "error", err)
+			return
+		}
+		// Events from replicas that predate submittedAt leave it empty.
+		submittedAt, _ := time.Parse(config.DateTimeFormat, p.SubmittedAt)
+		ps.summary.invalidate(requestedAt, submittedAt)
+	})
 
-	<-ctx.Done()
+	return ps
 }
 
-func (ps *PaymentService) worker(ctx context.Context, workerID int) {
-	for {
+func (ps *PaymentService) StartWorker(ctx context.Context, cfg config.WorkerPoolConfig) {
+	ps.pool = NewWorkerPool(cfg, ps)
+	ps.pool.Run(ctx)
+}
+
+func (ps *PaymentService) worker(ctx context.Context, retire <-chan struct{}, workerID int) {
+	for ctx.Err() == nil {
 		select {
-		case <-ctx.Done():
+		case <-retire:
 			return
 		default:
-			if err := ps.Process(); err != nil {
-				if !errors.Is(err, internalErrors.ErrNoPaymentsInQueue) &&
-					!errors.Is(err, internalErrors.ErrNoPaymentProcessorAvailable) &&
-					!errors.Is(err, context.DeadlineExceeded) {
-					slog.Error("Worker error", "workerID", workerID, "err", err)
+		}
+
+		if err := ps.Process(ctx); err != nil {
+			if !errors.Is(err, internalErrors.ErrNoPaymentsInQueue) &&
+				!errors.Is(err, internalErrors.ErrNoPaymentProcessorAvailable) &&
+				!errors.Is(err, internalErrors.ErrProcessorSaturated) &&
+				!errors.Is(err, context.DeadlineExceeded) &&
+				!errors.Is(err, context.Canceled) {
+				slog.Error("Worker error", "workerID", workerID, "err", err)
+			}
+		}
+	}
+}
+
+func (ps *PaymentService) Process(ctx context.Context) error {
+	payments, dequeueErr := ps.q.DequeueN(ctx, config.DequeueBatchSize)
+	if dequeueErr != nil && len(payments) == 0 {
+		return dequeueErr
+	}
+
+	processed := make([]*dtos.Payment, len(payments))
+	deadLettered := make([]*webhooks.Notification, len(payments))
+	errs := make([]error, len(payments), len(payments)+1)
+
+	var wg sync.WaitGroup
+	for i, payment := range payments {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			result, err := ps.pm.Dispatch(ctx, payment)
+			ps.latency.Observe(time.Since(start))
+			if err != nil {
+				errs[i] = err
+				if ps.retryOrDeadLetter(payment, err) {
+					deadLettered[i] = ps.notification(payment, webhooks.Event{
+						Type:  webhooks.EventPaymentDeadLettered,
+						Error: err.Error(),
+					})
 				}
+				return
+			}
+
+			if result.Hedged {
+				slog.Debug("hedged payment dispatch", "correlationId", payment.CorrelationId, "processor", result.Processor, "winningAttempt", result.Attempt)
 			}
 
+			payment.Processor = result.Processor
+			processed[i] = payment
+		}()
+	}
+	wg.Wait()
+
+	acknowledged := processed[:0]
+	for _, payment := range processed {
+		if payment != nil {
+			acknowledged = append(acknowledged, payment)
 		}
 	}
-}
 
-func (ps *PaymentService) Process() error {
-	payment, err := ps.q.Dequeue()
-	if err != nil {
-		return err
+	if ps.pool != nil {
+		ps.pool.record(len(acknowledged), len(payments)-len(acknowledged))
 	}
 
-	processorName, err := ps.pm.Dispatch(payment)
-	if err != nil {
-		ps.q.RequeueWithBackoff(payment)
+	now := time.Now().UTC()
+	for _, payment := range acknowledged {
+		payment.ProcessedAt = now
+	}
+
+	notifications := make([]webhooks.Notification, 0, len(payments))
+	for _, notification := range deadLettered {
+		if notification != nil {
+			notifications = append(notifications, *notification)
+		}
+	}
+
+	if err := ps.q.AcknowledgeBatch(acknowledged); err != nil {
+		ps.notify(notifications)
 		return err
 	}
 
-	payment.Processor = processorName
+	for _, payment := range acknowledged {
+		ps.summary.invalidate(payment.RequestedAt, payment.SubmittedAt)
+	}
+
+	processedAt := now.Format(config.DateTimeFormat)
+	published := make([]events.ProcessedPayment, 0, len(acknowledged))
+	for _, payment := range acknowledged {
+		submittedAt := payment.SubmittedAt.UTC().Format(config.DateTimeFormat)
+		notifications = append(notifications, *ps.notification(payment, webhooks.Event{
+			Type:        webhooks.EventPaymentProcessed,
+			Processor:   payment.Processor,
+			SubmittedAt: submittedAt,
+			ProcessedAt: processedAt,
+		}))
+
+		published = append(published, events.ProcessedPayment{
+			MerchantId:    payment.MerchantId,
+			CorrelationId: payment.CorrelationId,
+			Amount:        payment.Amount,
+			Currency:      currencyOrDefault(payment.Currency),
+			Processor:     payment.Processor,
+			RequestedAt:   payment.RequestedAt.UTC().Format(config.DateTimeFormat),
+			SubmittedAt:   submittedAt,
+			ProcessedAt:   processedAt,
+		})
+	}
+
+	ps.notify(notifications)
+
+	ps.hub.Publish(published...)
+
+	return errors.Join(append(errs, dequeueErr)...)
+}
+
+// retryOrDeadLetter reports whether the payment was dead-lettered.
+func (ps *PaymentService) retryOrDeadLetter(payment *dtos.Payment, cause error) bool {
+	delay, retry := ps.retry.Next(payment.RetryCount+1, payment.RetryDelay, cause)
+	if !retry || (ps.cfg.MaxRetries > 0 && payment.RetryCount >= ps.cfg.MaxRetries) {
+		if err := ps.q.DeadLetter(payment, cause); err != nil {
+			slog.Error("failed to dead-letter payment", "correlationId", payment.CorrelationId, "error", err)
+			return false
+		}
+		return true
+	}
+
+	if err := ps.q.RequeueWithBackoff(payment, delay, cause); err != nil {
+		slog.Error("failed to requeue payment", "correlationId", payment.CorrelationId, "error", err)
+	}
+	return false
+}
+
+func (ps *PaymentService) notification(payment *dtos.Payment, event webhooks.Event) *webhooks.Notification {
+	event.CorrelationId = payment.CorrelationId
+	event.Amount = payment.Amount
+	event.Currency = currencyOrDefault(payment.Currency)
+	event.RequestedAt = payment.RequestedAt.UTC().Format(config.DateTimeFormat)
+
+	return &webhooks.Notification{
+		MerchantId:  payment.MerchantId,
+		CallbackUrl: payment.CallbackUrl,
+		Event:       event,
+	}
+}
+
+func (ps *PaymentService) notify(notifications []webhooks.Notification) {
+	if err := ps.wh.Notify(context.Background(), notifications...); err != nil {
+		slog.Error("failed to enqueue webhook notifications", "notifications", len(notifications), "error", err)
+	}
+}
+
+func (ps *PaymentService) WebhooksEnabled() bool {
+	return ps.wh.Enabled()
+}
+
+func (ps *PaymentService) RegisterWebhook(ctx context.Context, merchantId, url string) error {
+	return ps.wh.Register(ctx, merchantId, url)
+}
+
+func (ps *PaymentService) UnregisterWebhook(ctx context.Context, merchantId, url string) error {
+	return ps.wh.Unregister(ctx, merchantId, url)
+}
 
-	return ps.q.Acknowledge(payment)
+func (ps *PaymentService) Webhooks(ctx context.Context, merchantId string) ([]string, error) {
+	return ps.wh.Endpoints(ctx, merchantId)
+}
+
+func (ps *PaymentService) SubscribeEvents(merchantId string) *events.Subscription {
+	return ps.hub.Subscribe(merchantId)
+}
+
+func (ps *PaymentService) UnsubscribeEvents(sub *events.Subscription) {
+	ps.hub.Unsubscribe(sub)
+}
+
+func (ps *PaymentService) GetStatus(ctx context.Context, merchantId, correlationId string) (*entities.PaymentStatus, error) {
+	status, err := ps.q.Status(ctx, merchantId, correlationId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entities.PaymentStatus{
+		CorrelationId: status.CorrelationId,
+		State:         status.State,
+		Amount:        status.Amount,
+		Currency:      status.Currency,
+		RequestedAt:   status.RequestedAt,
+		SubmittedAt:   status.SubmittedAt,
+		RetryCount:    status.RetryCount,
+		NextAttemptAt: status.NextAttemptAt,
+		Processor:     status.Processor,
+		ProcessedAt:   status.ProcessedAt,
+		Error:         status.Error,
+	}, nil
 }
 
 func (ps *PaymentService) Clear() error {
@@ -77,46 +285,205 @@ func (ps *PaymentService) Clear() error {
 		return errors.New("cannot purge payments queue: " + err.Error())
 	}
 
+	ps.summary.reset()
+
 	return nil
 }
 
-func (ps *PaymentService) GetSummary(filters dtos.GetPaymentsSummaryFilters) (*entities.PaymentsSummary, error) {
+func summaryFiltersJson(filters dtos.GetPaymentsSummaryFilters) dtos.GetPaymentsSummaryFiltersJson {
 	var fromStr, toStr string
 
 	if !filters.From.IsZero() {
-		fromStr = filters.From.UTC().Format(config.DateTimeFormat)
+		fromStr = filters.From.UTC().Format(time.RFC3339Nano)
 	}
 
 	if !filters.To.IsZero() {
-		toStr = filters.To.UTC().Format(config.DateTimeFormat)
+		toStr = filters.To.UTC().Format(time.RFC3339Nano)
 	}
 
-	summary, err := ps.q.Summary(dtos.GetPaymentsSummaryFiltersJson{
-		From: fromStr,
-		To:   toStr,
+	return dtos.GetPaymentsSummaryFiltersJson{
+		MerchantId:    filters.MerchantId,
+		From:          fromStr,
+		To:            toStr,
+		FromExclusive: filters.FromExclusive,
+		ToExclusive:   filters.ToExclusive,
+		TimeField:     filters.TimeField,
+	}
+}
+
+// ExportPayments streams the raw payments in the window. Compacted payments
+// only survive as rollups, so a window reaching into them fails with
+// ErrExportWindowCompacted, before anything is streamed or, if compaction
+// reached the window during the export, once it is done.
+func (ps *PaymentService) ExportPayments(ctx context.Context, filters dtos.GetPaymentsSummaryFilters, processor string, fn func(*dtos.Payment) error) error {
+	f := summaryFiltersJson(filters)
+
+	generation, err := ps.q.RollupGeneration(ctx, f.MerchantId)
+	if err != nil {
+		return err
+	}
+	if err := ps.refuseCompacted(ctx, f); err != nil {
+		return err
+	}
+
+	err = ps.q.Scan(ctx, f, exportBatchSize, func(payment *dtos.Payment) error {
+		if payment.Processor == "" {
+			payment.Processor = config.DefaultProcessor
+		}
+
+		if processor != "" && payment.Processor != processor {
+			return nil
+		}
+
+		return fn(payment)
+	})
+	if err != nil {
+		return err
+	}
+
+	after, err := ps.q.RollupGeneration(ctx, f.MerchantId)
+	if err != nil || after == generation {
+		return err
+	}
+	return ps.refuseCompacted(ctx, f)
+}
+
+func (ps *PaymentService) refuseCompacted(ctx context.Context, f dtos.GetPaymentsSummaryFiltersJson) error {
+	err := ps.q.ScanRollups(ctx, f, 1, func(*dtos.Rollup) error {
+		return internalErrors.ErrExportWindowCompacted
 	})
+	if errors.Is(err, internalErrors.ErrSummaryWindowMisaligned) {
+		return internalErrors.ErrExportWindowCompacted
+	}
+	return err
+}
+
+func (ps *PaymentService) GetSummary(filters dtos.GetPaymentsSummaryFilters, opts dtos.SummaryOptions) (*entities.PaymentsSummary, error) {
+	key := summaryCacheKey(filters, opts)
+	cached, read, ok := ps.summary.get(key, filters)
+	if ok {
+		return cached, nil
+	}
+
+	var summary *entities.PaymentsSummary
+	var err error
+	for range summaryReadAttempts {
+		summary, err = ps.readSummary(context.Background(), filters, opts)
+		if err != errSummaryCompacted {
+			break
+		}
+	}
 	if err != nil {
+		ps.summary.abandon(read)
 		return nil, err
 	}
 
-	return &entities.PaymentsSummary{
-		Default: entities.PaymentStats{
-			TotalRequests: summary.Default.TotalRequests,
-			TotalAmount:   summary.Default.TotalAmount,
-		},
-		Fallback: entities.PaymentStats{
-			TotalRequests: summary.Fallback.TotalRequests,
-			TotalAmount:   summary.Fallback.TotalAmount,
-		},
-	}, nil
+	ps.summary.put(key, read, summary)
+
+	return summary, nil
 }
 
-func (ps *PaymentService) RequestProcessing(correlationId string, amount float64) error {
-	payment := &dtos.Payment{
-		CorrelationId: correlationId,
-		Amount:        amount,
-		RequestedAt:   time.Now().UTC(),
+// readSummary combines raw processed payments with rollups of compacted ones.
+// A compaction between the two reads could count a payment in both or in
+// neither, so the read is rejected when the rollup generation moved.
+func (ps *PaymentService) readSummary(ctx context.Context, filters dtos.GetPaymentsSummaryFilters, opts dtos.SummaryOptions) (*entities.PaymentsSummary, error) {
+	before, err := ps.q.RollupGeneration(ctx, filters.MerchantId)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := newSummaryBuilder(opts, filters.TimeField)
+	defer builder.release()
+	f := summaryFiltersJson(filters)
+
+	err = ps.q.Scan(ctx, f, summaryBatchSize, func(payment *dtos.Payment) error {
+		builder.add(payment)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return ps.q.Enqueue(payment)
+	err = ps.q.ScanRollups(ctx, f, summaryBatchSize, func(rollup *dtos.Rollup) error {
+		builder.addRollup(rollup)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := ps.q.RollupGeneration(ctx, filters.MerchantId)
+	if err != nil {
+		return nil, err
+	}
+	if after != before {
+		return nil, errSummaryCompacted
+	}
+
+	return builder.build(), nil
+}
+
+// RequestProcessing falls back to the write-ahead log, when there is one, if
+// the payment cannot be queued, since the client was already told it was
+// accepted. Replaying it later keeps its original RequestedAt.
+func (ps *PaymentService) RequestProcessing(request dtos.CreatePaymentRequest) error {
+	payment := newPayment(request, time.Now().UTC())
+
+	err := ps.q.Enqueue(payment)
+	if err == nil || ps.wal == nil {
+		return err
+	}
+
+	if walErr := ps.wal.Append(payment); walErr != nil {
+		return errors.Join(err, walErr)
+	}
+	return nil
+}
+
+func (ps *PaymentService) RequestProcessingBatch(ctx context.Context, requests []dtos.CreatePaymentRequest) error {
+	now := time.Now().UTC()
+
+	payments := make([]*dtos.Payment, len(requests))
+	for i, request := range requests {
+		payments[i] = newPayment(request, now)
+	}
+
+	err := ps.q.EnqueueBatch(ctx, payments)
+	if err == nil || ps.wal == nil {
+		return err
+	}
+
+	if walErr := ps.wal.Append(payments...); walErr != nil {
+		return errors.Join(err, walErr)
+	}
+	return nil
+}
+
+// Spill persists payments that must not wait on the queue, such as the ones
+// still buffered when shutdown runs out of time. They go straight to the
+// write-ahead log, which replays them on the next start; without one they
+// get a last enqueue attempt.
+func (ps *PaymentService) Spill(requests []dtos.CreatePaymentRequest) error {
+	now := time.Now().UTC()
+
+	payments := make([]*dtos.Payment, len(requests))
+	for i, request := range requests {
+		payments[i] = newPayment(request, now)
+	}
+
+	if ps.wal == nil {
+		return ps.q.EnqueueBatch(context.Background(), payments)
+	}
+	return ps.wal.Append(payments...)
+}
+
+func newPayment(request dtos.CreatePaymentRequest, requestedAt time.Time) *dtos.Payment {
+	return &dtos.Payment{
+		CorrelationId: request.CorrelationId,
+		Amount:        request.Amount,
+		RequestedAt:   requestedAt,
+		CallbackUrl:   request.CallbackUrl,
+		MerchantId:    request.MerchantId,
+		Currency:      request.Currency,
+	}
 }
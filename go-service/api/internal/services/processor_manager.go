@@ -1,10 +1,19 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"go-service/internal/config"
 	"go-service/internal/dtos"
 	internalErrors "go-service/internal/errors"
 	"go-service/internal/gateway"
+	"go-service/internal/merchants"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -17,20 +26,39 @@ type ProcessorStatus struct {
 }
 
 type ProcessorManager struct {
-	rc  *redis.Client
-	ppp gateway.PaymentProcessorInterface
-	spp gateway.PaymentProcessorInterface
+	rc        *redis.Client
+	ppp       gateway.PaymentProcessorInterface
+	spp       gateway.PaymentProcessorInterface
+	hedging   config.HedgingConfig
+	merchants *merchants.Registry
+	// currencyRoutes restricts a currency to the listed processors.
+	currencyRoutes map[string][]string
+
+	mu        sync.RWMutex
+	status    map[string]ProcessorStatus
+	latencies map[string]*latencyTracker
 }
 
 func NewProcessorManager(
 	redisClient *redis.Client,
 	ppp gateway.PaymentProcessorInterface,
 	spp gateway.PaymentProcessorInterface,
+	hedging config.HedgingConfig,
+	merchants *merchants.Registry,
+	currencyRoutes map[string][]string,
 ) *ProcessorManager {
 	return &ProcessorManager{
-		rc:  redisClient,
-		ppp: ppp,
-		spp: spp,
+		rc:             redisClient,
+		ppp:            ppp,
+		spp:            spp,
+		hedging:        hedging,
+		merchants:      merchants,
+		currencyRoutes: currencyRoutes,
+		status:         make(map[string]ProcessorStatus),
+		latencies: map[string]*latencyTracker{
+			config.DefaultProcessor:  {},
+			config.FallbackProcessor: {},
+		},
 	}
 }
 
@@ -39,19 +67,303 @@ type ProcessorChoice struct {
 	ExpectedResponseTime int
 }
 
-func (pm *ProcessorManager) Dispatch(payment *dtos.Payment) (string, error) {
-	err := pm.ppp.Process(*payment)
-	if err == nil {
-		return "default", nil
+type DispatchResult struct {
+	Processor string
+	Attempt   int
+	Hedged    bool
+}
+
+type attemptResult struct {
+	attempt int
+	err     error
+}
+
+func (pm *ProcessorManager) Dispatch(ctx context.Context, payment *dtos.Payment) (DispatchResult, error) {
+	attempt := 0
+	saturated := true
+	var errs []error
+
+	// The first dispatch fixes the submission time and the queue keeps it
+	// through requeues, so every attempt sends the processor the same value.
+	// Processors only see millisecond precision, so truncating keeps the
+	// stored time identical to the one they bucket by.
+	if payment.SubmittedAt.IsZero() {
+		payment.SubmittedAt = time.Now().UTC().Truncate(time.Millisecond)
+	}
+
+	for _, name := range pm.route(payment) {
+		attempt++
+		result, err := pm.attempt(ctx, name, payment, &attempt)
+		if err == nil {
+			return result, nil
+		}
+
+		// The payment may have reached this processor, so failing over could
+		// charge it on both. It is pinned here instead, and the retry either
+		// goes through or gets a duplicate reply that settles it.
+		if ambiguousFailure(err) {
+			payment.Processor = name
+			return DispatchResult{}, fmt.Errorf("%w: %w", internalErrors.ErrNoPaymentProcessorAvailable, err)
+		}
+
+		saturated = saturated && errors.Is(err, internalErrors.ErrProcessorSaturated)
+		errs = append(errs, err)
+	}
+
+	if saturated {
+		return DispatchResult{}, internalErrors.ErrProcessorSaturated
+	}
+
+	// Keep each processor's error so retry policies can tell why it failed.
+	return DispatchResult{}, fmt.Errorf("%w: %w", internalErrors.ErrNoPaymentProcessorAvailable, errors.Join(errs...))
+}
+
+// route drops the processors a currency route does not allow, keeping the
+// order picked by preferredRoute. If none of them is allowed, the currency
+// route's own order is used. A queued payment only carries a processor when
+// an earlier attempt there ended ambiguously, and then it stays on it.
+func (pm *ProcessorManager) route(payment *dtos.Payment) []string {
+	if payment.Processor != "" {
+		return []string{payment.Processor}
+	}
+
+	preferred := pm.preferredRoute(payment)
+
+	allowed, ok := pm.currencyRoutes[currencyOrDefault(payment.Currency)]
+	if !ok {
+		return preferred
+	}
+
+	route := make([]string, 0, len(preferred))
+	for _, name := range preferred {
+		if slices.Contains(allowed, name) {
+			route = append(route, name)
+		}
+	}
+	if len(route) == 0 {
+		return allowed
+	}
+	return route
+}
+
+// preferredRoute honours the merchant's processor preference when it has one.
+// Otherwise it prefers the cheaper processor and only puts the pricier one
+// first when the health check says the cheaper is failing and the other is not.
+func (pm *ProcessorManager) preferredRoute(payment *dtos.Payment) []string {
+	if preferred := pm.merchants.Get(payment.MerchantId).Processors; len(preferred) > 0 {
+		return preferred
+	}
+
+	cheaper, pricier := config.DefaultProcessor, config.FallbackProcessor
+	if config.ProcessorFee(pricier) < config.ProcessorFee(cheaper) {
+		cheaper, pricier = pricier, cheaper
+	}
+
+	cheaperStatus, _ := pm.Status(cheaper)
+	pricierStatus, _ := pm.Status(pricier)
+	if cheaperStatus.Failing && !pricierStatus.Failing {
+		return []string{pricier, cheaper}
+	}
+
+	return []string{cheaper, pricier}
+}
+
+func (pm *ProcessorManager) processor(name string) gateway.PaymentProcessorInterface {
+	if name == config.FallbackProcessor {
+		return pm.spp
+	}
+	return pm.ppp
+}
+
+// errMaybeAccepted marks an attempt in which at least one request failed in a
+// way that may still have reached the processor.
+var errMaybeAccepted = errors.New("a request may have reached the processor")
+
+// attempt sends the payment to a single processor. With hedging enabled, a
+// second request for the same correlationId goes to the same processor once the
+// first has been outstanding for the hedge delay. That processor deduplicates
+// on correlationId, so the hedge cannot count the payment twice. The hedge
+// never goes to the other processor even when it is cheaper or healthier: the
+// processors do not share correlationIds, so that could charge the payment on
+// both, and fee and health already decided which processor comes first.
+//
+// When every request fails, all their errors are returned, and if any of them
+// is ambiguous the whole attempt is, so Dispatch does not fail over a payment
+// the processor may have accepted.
+func (pm *ProcessorManager) attempt(ctx context.Context, name string, payment *dtos.Payment, attempt *int) (DispatchResult, error) {
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan attemptResult, 2)
+	send := func(n int) {
+		start := time.Now()
+		err := pm.processor(name).Process(attemptCtx, *payment)
+		if err == nil || errors.Is(err, internalErrors.ErrDuplicatePayment) {
+			pm.latencies[name].Observe(time.Since(start))
+		}
+		results <- attemptResult{attempt: n, err: err}
+	}
+
+	first := *attempt
+	go send(first)
+
+	var hedge <-chan time.Time
+	if pm.hedging.Enabled {
+		timer := time.NewTimer(pm.hedgeDelay(name))
+		defer timer.Stop()
+		hedge = timer.C
+	}
+
+	pending := 1
+	var errs []error
+	ambiguous := false
+	for pending > 0 {
+		select {
+		case <-hedge:
+			hedge = nil
+			*attempt++
+			pending++
+			go send(*attempt)
+		case res := <-results:
+			pending--
+			// A duplicate means a sibling request or an earlier dispatch of this
+			// payment already reached the processor, which is as good as a success.
+			if res.err == nil || errors.Is(res.err, internalErrors.ErrDuplicatePayment) {
+				return DispatchResult{
+					Processor: name,
+					Attempt:   res.attempt,
+					Hedged:    *attempt != first,
+				}, nil
+			}
+
+			errs = append(errs, res.err)
+			ambiguous = ambiguous || ambiguousFailure(res.err)
+			hedge = nil
+		}
+	}
+
+	if ambiguous {
+		return DispatchResult{}, fmt.Errorf("%w: %w", errMaybeAccepted, errors.Join(errs...))
+	}
+	return DispatchResult{}, errors.Join(errs...)
+}
+
+// ambiguousFailure reports whether a failed request may still have been
+// accepted: anything but a status reply, a local limit or a refused dial.
+func ambiguousFailure(err error) bool {
+	if errors.Is(err, errMaybeAccepted) {
+		return true
 	}
 
-	err = pm.spp.Process(*payment)
-	if err == nil {
-		return "fallback", nil
+	var processorErr *internalErrors.ProcessorError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &processorErr),
+		errors.Is(err, internalErrors.ErrProcessorSaturated),
+		errors.As(err, &opErr) && opErr.Op == "dial":
+		return false
+	}
+	return true
+}
+
+func (pm *ProcessorManager) hedgeDelay(name string) time.Duration {
+	if pm.hedging.Delay > 0 {
+		return pm.hedging.Delay
 	}
 
-	return "", internalErrors.ErrNoPaymentProcessorAvailable
+	delay, ok := pm.latencies[name].Percentile(pm.hedging.Percentile)
+	if !ok {
+		if status, ok := pm.Status(name); ok {
+			delay = time.Duration(status.MinResponseTime) * time.Millisecond
+		}
+	}
+
+	return max(delay, pm.hedging.MinDelay)
+}
+
+func (pm *ProcessorManager) Status(name string) (ProcessorStatus, bool) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	status, ok := pm.status[name]
+	return status, ok
+}
+
+func (pm *ProcessorManager) StartHealthMonitor(ctx context.Context) {
+	ticker := time.NewTicker(config.HealthCheckCooldown)
+	defer ticker.Stop()
+
+	pm.refreshHealth(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pm.refreshHealth(ctx)
+		}
+	}
+}
+
+func (pm *ProcessorManager) refreshHealth(ctx context.Context) {
+	processors := map[string]gateway.PaymentProcessorInterface{
+		config.DefaultProcessor:  pm.ppp,
+		config.FallbackProcessor: pm.spp,
+	}
+
+	for name, processor := range processors {
+		status, err := pm.healthOf(ctx, name, processor)
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			slog.Error("failed to refresh processor health", "processor", name, "error", err)
+			continue
+		}
+
+		pm.mu.Lock()
+		pm.status[name] = status
+		pm.mu.Unlock()
+	}
+}
+
+// The processors only allow one health check every few seconds, so a single
+// replica holds a short lock and shares the result with the others through Redis.
+func (pm *ProcessorManager) healthOf(ctx context.Context, name string, processor gateway.PaymentProcessorInterface) (ProcessorStatus, error) {
+	key := "processors:health:" + name
+	lockKey := key + ":lock"
+
+	acquired, err := pm.rc.SetNX(ctx, lockKey, 1, config.HealthCheckCooldown).Result()
+	if err != nil {
+		return ProcessorStatus{}, err
+	}
+
+	var status ProcessorStatus
+	if !acquired {
+		cached, err := pm.rc.Get(ctx, key).Bytes()
+		if err != nil {
+			return ProcessorStatus{}, err
+		}
+		err = json.Unmarshal(cached, &status)
+		return status, err
+	}
+
+	failing, minResponseTime, err := processor.Healthcheck()
+	if err != nil {
+		slog.Warn("processor health check failed", "processor", name, "error", err)
+	}
+
+	status = ProcessorStatus{
+		Failing:         failing,
+		MinResponseTime: minResponseTime,
+		LastChecked:     time.Now().UTC(),
+	}
+
+	payload, err := json.Marshal(status)
+	if err != nil {
+		return ProcessorStatus{}, err
+	}
 
+	return status, pm.rc.Set(ctx, key, payload, 3*config.HealthCheckCooldown).Err()
 }
 
 func (pm *ProcessorManager) Clear() error {
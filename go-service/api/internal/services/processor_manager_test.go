@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"go-service/internal/config"
+	"go-service/internal/dtos"
+	internalErrors "go-service/internal/errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type scriptedProcessor struct {
+	calls   atomic.Int32
+	replies []func(ctx context.Context) error
+}
+
+func (p *scriptedProcessor) Healthcheck() (bool, int, error) { return false, 0, nil }
+func (p *scriptedProcessor) Clear() error                    { return nil }
+
+func (p *scriptedProcessor) Process(ctx context.Context, _ dtos.Payment) error {
+	n := int(p.calls.Add(1)) - 1
+	return p.replies[min(n, len(p.replies)-1)](ctx)
+}
+
+func TestHedgedAttemptStaysAmbiguous(t *testing.T) {
+	processor := &scriptedProcessor{replies: []func(context.Context) error{
+		// The original request is slow and finally gets a plain 500...
+		func(context.Context) error {
+			time.Sleep(30 * time.Millisecond)
+			return &internalErrors.ProcessorError{StatusCode: 500}
+		},
+		// ...after the hedge already timed out without an answer.
+		func(context.Context) error {
+			return context.DeadlineExceeded
+		},
+	}}
+
+	pm := &ProcessorManager{
+		ppp:       processor,
+		spp:       processor,
+		hedging:   config.HedgingConfig{Enabled: true, Delay: time.Millisecond},
+		latencies: map[string]*latencyTracker{config.DefaultProcessor: {}},
+	}
+
+	attempt := 1
+	_, err := pm.attempt(context.Background(), config.DefaultProcessor, &dtos.Payment{CorrelationId: "c"}, &attempt)
+	if err == nil {
+		t.Fatal("attempt succeeded")
+	}
+	if !ambiguousFailure(err) {
+		t.Fatalf("attempt = %v, want an ambiguous failure", err)
+	}
+	if processor.calls.Load() != 2 {
+		t.Fatalf("processor saw %d requests, want the original and a hedge", processor.calls.Load())
+	}
+}
+
+func TestUnhedgedStatusReplyIsNotAmbiguous(t *testing.T) {
+	processor := &scriptedProcessor{replies: []func(context.Context) error{
+		func(context.Context) error {
+			return &internalErrors.ProcessorError{StatusCode: 500}
+		},
+	}}
+
+	pm := &ProcessorManager{
+		ppp:       processor,
+		latencies: map[string]*latencyTracker{config.DefaultProcessor: {}},
+	}
+
+	attempt := 1
+	_, err := pm.attempt(context.Background(), config.DefaultProcessor, &dtos.Payment{CorrelationId: "c"}, &attempt)
+	if err == nil || ambiguousFailure(err) {
+		t.Fatalf("attempt = %v, want a non-ambiguous failure", err)
+	}
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"go-service/internal/config"
+	internalErrors "go-service/internal/errors"
+	"math/rand/v2"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy decides whether a failed payment is tried again and after how
+// long. attempt counts from 1 and previous is the delay used before it.
+type RetryPolicy interface {
+	Next(attempt int, previous time.Duration, cause error) (time.Duration, bool)
+}
+
+// ExponentialJitter waits a random time up to Base·2^(attempt-1), capped at
+// Max, which is the "full jitter" schedule.
+type ExponentialJitter struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (p ExponentialJitter) Next(attempt int, _ time.Duration, _ error) (time.Duration, bool) {
+	ceiling := p.Max
+	if shift := attempt - 1; shift < 32 && p.Base<<shift < p.Max {
+		ceiling = p.Base << shift
+	}
+	return randomUpTo(ceiling), true
+}
+
+// DecorrelatedJitter waits between Base and three times the previous delay,
+// capped at Max, so consecutive delays grow without moving in lockstep.
+type DecorrelatedJitter struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (p DecorrelatedJitter) Next(_ int, previous time.Duration, _ error) (time.Duration, bool) {
+	previous = max(previous, p.Base)
+	return min(p.Max, p.Base+randomUpTo(3*previous-p.Base)), true
+}
+
+// FixedSchedule waits Delays[attempt-1], repeating the last entry.
+type FixedSchedule struct {
+	Delays []time.Duration
+}
+
+func (p FixedSchedule) Next(attempt int, _ time.Duration, _ error) (time.Duration, bool) {
+	if len(p.Delays) == 0 {
+		return 0, true
+	}
+	return p.Delays[min(max(attempt, 1), len(p.Delays))-1], true
+}
+
+func randomUpTo(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d + 1)
+}
+
+// ErrorClassPolicy picks the schedule from what went wrong: a 429 waits for
+// its Retry-After, a refused connection is retried on ConnRefused, any other
+// 4xx gives up and everything else follows Default.
+type ErrorClassPolicy struct {
+	Default     RetryPolicy
+	ConnRefused RetryPolicy
+}
+
+func (p ErrorClassPolicy) Next(attempt int, previous time.Duration, cause error) (time.Duration, bool) {
+	class, retryAfter := classifyError(cause)
+	switch class {
+	case errorPermanent:
+		return 0, false
+	case errorRateLimited:
+		if retryAfter > 0 {
+			return retryAfter, true
+		}
+	case errorConnRefused:
+		return p.ConnRefused.Next(attempt, previous, cause)
+	}
+	return p.Default.Next(attempt, previous, cause)
+}
+
+func NewRetryPolicy(cfg config.RetryConfig) RetryPolicy {
+	var schedule RetryPolicy
+	switch cfg.Strategy {
+	case config.RetryDecorrelated:
+		schedule = DecorrelatedJitter{Base: cfg.BaseDelay, Max: cfg.MaxDelay}
+	case config.RetryFixed:
+		schedule = FixedSchedule{Delays: cfg.Schedule}
+	default:
+		schedule = ExponentialJitter{Base: cfg.BaseDelay, Max: cfg.MaxDelay}
+	}
+
+	return ErrorClassPolicy{
+		Default:     schedule,
+		ConnRefused: FixedSchedule{Delays: []time.Duration{cfg.ConnRefusedDelay}},
+	}
+}
+
+type errorClass int
+
+// Ordered by precedence when a dispatch failed on several processors.
+const (
+	errorUnknown errorClass = iota
+	errorPermanent
+	errorConnRefused
+	errorTransient
+	errorRateLimited
+)
+
+// classifyError looks through joined errors, such as one per processor tried,
+// and keeps the most retryable class so a payment is only given up on when
+// every processor rejected it. Sentinels that carry no cause are ignored.
+func classifyError(err error) (errorClass, time.Duration) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		best, retryAfter := errorUnknown, time.Duration(0)
+		for _, child := range joined.Unwrap() {
+			class, after := classifyError(child)
+			if class > best {
+				best = class
+			}
+			retryAfter = max(retryAfter, after)
+		}
+		if best == errorUnknown {
+			best = errorTransient
+		}
+		return best, retryAfter
+	}
+
+	var processorErr *internalErrors.ProcessorError
+	switch {
+	case err == nil,
+		err == internalErrors.ErrNoPaymentProcessorAvailable:
+		return errorUnknown, 0
+	case errors.As(err, &processorErr):
+		switch code := processorErr.StatusCode; {
+		case code == http.StatusTooManyRequests:
+			return errorRateLimited, processorErr.RetryAfter
+		case code >= 400 && code < 500 && code != http.StatusRequestTimeout:
+			return errorPermanent, 0
+		}
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errorConnRefused, 0
+	}
+	return errorTransient, 0
+}
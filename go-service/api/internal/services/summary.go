@@ -0,0 +1,251 @@
+package services
+
+import (
+	"go-service/internal/config"
+	"go-service/internal/dtos"
+	"go-service/internal/entities"
+	"math/bits"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Latencies are kept in a log-linear millisecond histogram so percentiles
+// over large windows do not cost memory proportional to the number of
+// payments. Below 2*latencySubBuckets ms every millisecond has its own
+// bucket; above, each power of two is split into latencySubBuckets buckets,
+// which bounds the reported error to about 3%.
+const (
+	maxTrackedLatencyMs  = 60_000
+	latencySubBucketBits = 5
+	latencySubBuckets    = 1 << latencySubBucketBits
+	latencyLinearBuckets = 2 * latencySubBuckets
+	// latencyBucketCount is latencyBucket(maxTrackedLatencyMs) + 1.
+	latencyBucketCount = 379
+)
+
+var latencyHistograms = sync.Pool{
+	New: func() any { return new(latencyHistogram) },
+}
+
+type latencyHistogram struct {
+	counts [latencyBucketCount]int64
+	total  int64
+	max    int64
+}
+
+func latencyBucket(ms int64) int {
+	if ms < latencyLinearBuckets {
+		return int(ms)
+	}
+	shift := bits.Len64(uint64(ms)) - 1 - latencySubBucketBits
+	return latencyLinearBuckets + (shift-1)*latencySubBuckets + int(ms>>shift) - latencySubBuckets
+}
+
+// latencyBucketUpper returns the largest latency, in ms, counted by bucket i.
+func latencyBucketUpper(i int) int64 {
+	if i < latencyLinearBuckets {
+		return int64(i)
+	}
+	shift := (i-latencyLinearBuckets)/latencySubBuckets + 1
+	sub := int64((i-latencyLinearBuckets)%latencySubBuckets + latencySubBuckets)
+	return (sub+1)<<shift - 1
+}
+
+func (h *latencyHistogram) add(d time.Duration) {
+	ms := max(d.Milliseconds(), 0)
+	h.max = max(h.max, ms)
+	h.counts[latencyBucket(min(ms, maxTrackedLatencyMs))]++
+	h.total++
+}
+
+func (h *latencyHistogram) percentiles() *entities.LatencyPercentiles {
+	if h.total == 0 {
+		return nil
+	}
+
+	quantile := func(q float64) time.Duration {
+		rank := int64(q * float64(h.total-1))
+		var seen int64
+		for i, count := range h.counts {
+			seen += count
+			if seen > rank {
+				return time.Duration(min(latencyBucketUpper(i), h.max)) * time.Millisecond
+			}
+		}
+		return time.Duration(h.max) * time.Millisecond
+	}
+
+	return &entities.LatencyPercentiles{
+		P50: quantile(0.50),
+		P90: quantile(0.90),
+		P95: quantile(0.95),
+		P99: quantile(0.99),
+		Max: time.Duration(h.max) * time.Millisecond,
+	}
+}
+
+type summaryBuilder struct {
+	opts      dtos.SummaryOptions
+	timeField string
+	fees      map[string]float64
+	stats     map[string]*entities.PaymentStats
+	latencies map[string]*latencyHistogram
+	buckets   map[int64]*entities.SummaryBucket
+	order     []int64
+}
+
+func newSummaryBuilder(opts dtos.SummaryOptions, timeField string) *summaryBuilder {
+	sb := &summaryBuilder{
+		opts:      opts,
+		timeField: timeField,
+		fees: map[string]float64{
+			config.DefaultProcessor:  config.ProcessorFee(config.DefaultProcessor),
+			config.FallbackProcessor: config.ProcessorFee(config.FallbackProcessor),
+		},
+		stats: map[string]*entities.PaymentStats{
+			config.DefaultProcessor:  {},
+			config.FallbackProcessor: {},
+		},
+		buckets: make(map[int64]*entities.SummaryBucket),
+	}
+
+	if opts.Latency {
+		sb.latencies = map[string]*latencyHistogram{
+			config.DefaultProcessor:  latencyHistograms.Get().(*latencyHistogram),
+			config.FallbackProcessor: latencyHistograms.Get().(*latencyHistogram),
+		}
+	}
+
+	return sb
+}
+
+func (sb *summaryBuilder) add(payment *dtos.Payment) {
+	processor := payment.Processor
+	if processor == "" {
+		processor = config.DefaultProcessor
+	}
+
+	stats, ok := sb.stats[processor]
+	if !ok {
+		return
+	}
+	feeRate := sb.fees[processor]
+	currency := currencyOrDefault(payment.Currency)
+	addToStats(stats, feeRate, currency, 1, payment.Amount)
+
+	if sb.latencies != nil && !payment.ProcessedAt.IsZero() {
+		sb.latencies[processor].add(payment.ProcessedAt.Sub(payment.RequestedAt))
+	}
+
+	if sb.opts.GroupBy > 0 {
+		bucket := sb.bucket(paymentTime(payment, sb.timeField))
+		if processor == config.FallbackProcessor {
+			addToStats(&bucket.Fallback, feeRate, currency, 1, payment.Amount)
+		} else {
+			addToStats(&bucket.Default, feeRate, currency, 1, payment.Amount)
+		}
+	}
+}
+
+// addRollup counts a compacted bucket. Rollups carry no latencies, so
+// percentiles only cover payments still kept raw.
+func (sb *summaryBuilder) addRollup(rollup *dtos.Rollup) {
+	for _, total := range rollup.Totals {
+		stats, ok := sb.stats[total.Processor]
+		if !ok {
+			continue
+		}
+		feeRate := sb.fees[total.Processor]
+		addToStats(stats, feeRate, total.Currency, total.Count, total.Amount)
+
+		if sb.opts.GroupBy > 0 {
+			bucket := sb.bucket(rollup.Start)
+			if total.Processor == config.FallbackProcessor {
+				addToStats(&bucket.Fallback, feeRate, total.Currency, total.Count, total.Amount)
+			} else {
+				addToStats(&bucket.Default, feeRate, total.Currency, total.Count, total.Amount)
+			}
+		}
+	}
+}
+
+func (sb *summaryBuilder) bucket(at time.Time) *entities.SummaryBucket {
+	start := at.Truncate(sb.opts.GroupBy)
+	bucket, ok := sb.buckets[start.UnixMilli()]
+	if !ok {
+		bucket = &entities.SummaryBucket{Start: start.UTC()}
+		sb.buckets[start.UnixMilli()] = bucket
+		sb.order = append(sb.order, start.UnixMilli())
+	}
+	return bucket
+}
+
+// addToStats only adds default currency amounts to the top level totals;
+// every currency, the default included, is tallied in ByCurrency.
+func addToStats(stats *entities.PaymentStats, feeRate float64, currency string, count int64, amount float64) {
+	fee := amount * feeRate
+
+	if currency == config.DefaultCurrency {
+		stats.TotalRequests += count
+		stats.TotalAmount += amount
+		stats.TotalFee += fee
+		stats.NetAmount += amount - fee
+	}
+
+	if stats.ByCurrency == nil {
+		stats.ByCurrency = make(map[string]entities.CurrencyStats)
+	}
+	byCurrency := stats.ByCurrency[currency]
+	byCurrency.TotalRequests += count
+	byCurrency.TotalAmount += amount
+	byCurrency.TotalFee += fee
+	byCurrency.NetAmount += amount - fee
+	stats.ByCurrency[currency] = byCurrency
+}
+
+// paymentTime falls back to RequestedAt for payments acknowledged before
+// submission times were recorded.
+func paymentTime(payment *dtos.Payment, timeField string) time.Time {
+	if timeField == dtos.TimeFieldSubmitted && !payment.SubmittedAt.IsZero() {
+		return payment.SubmittedAt
+	}
+	return payment.RequestedAt
+}
+
+func currencyOrDefault(currency string) string {
+	if currency == "" {
+		return config.DefaultCurrency
+	}
+	return currency
+}
+
+// release hands the latency histograms back to the pool. The builder must
+// not be used afterwards.
+func (sb *summaryBuilder) release() {
+	for _, h := range sb.latencies {
+		*h = latencyHistogram{}
+		latencyHistograms.Put(h)
+	}
+	sb.latencies = nil
+}
+
+func (sb *summaryBuilder) build() *entities.PaymentsSummary {
+	summary := &entities.PaymentsSummary{
+		Default:  *sb.stats[config.DefaultProcessor],
+		Fallback: *sb.stats[config.FallbackProcessor],
+	}
+
+	if sb.latencies != nil {
+		summary.Default.Latency = sb.latencies[config.DefaultProcessor].percentiles()
+		summary.Fallback.Latency = sb.latencies[config.FallbackProcessor].percentiles()
+	}
+
+	// Raw payments and rollups each arrive in time order, but interleave.
+	slices.Sort(sb.order)
+	for _, start := range sb.order {
+		summary.Buckets = append(summary.Buckets, *sb.buckets[start])
+	}
+
+	return summary
+}
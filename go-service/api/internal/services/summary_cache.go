@@ -0,0 +1,160 @@
+package services
+
+import (
+	"fmt"
+	"go-service/internal/dtos"
+	"go-service/internal/entities"
+	"go-service/internal/metrics"
+	"sync"
+	"time"
+)
+
+var (
+	summaryCacheHits   = metrics.NewCounter("summary_cache_requests_total", "Summary cache lookups by outcome.", "result", "hit")
+	summaryCacheMisses = metrics.NewCounter("summary_cache_requests_total", "Summary cache lookups by outcome.", "result", "miss")
+)
+
+// summaryWindow is the time window a summary filtered on.
+type summaryWindow struct {
+	from      time.Time
+	to        time.Time
+	timeField string
+}
+
+// covers reports whether a payment acknowledged with these times falls
+// inside the window. Bounds are compared with a millisecond of slack either
+// side, which is the precision scores are stored with.
+func (w summaryWindow) covers(requestedAt, submittedAt time.Time) bool {
+	at := requestedAt
+	if w.timeField == dtos.TimeFieldSubmitted && !submittedAt.IsZero() {
+		at = submittedAt
+	}
+	if !w.from.IsZero() && at.Before(w.from.Add(-time.Millisecond)) {
+		return false
+	}
+	if !w.to.IsZero() && at.After(w.to.Add(time.Millisecond)) {
+		return false
+	}
+	return true
+}
+
+type summaryCacheEntry struct {
+	summary *entities.PaymentsSummary
+	window  summaryWindow
+	expires time.Time
+}
+
+// summaryRead tracks a summary being read from Redis after a cache miss.
+// stale is set when a payment inside its window is acknowledged before the
+// read is stored, since the read may already be missing that payment.
+type summaryRead struct {
+	window summaryWindow
+	stale  bool
+}
+
+// summaryCache holds recently computed summaries for a short TTL. An entry is
+// dropped when a payment whose requested or submitted time, whichever the
+// entry filtered on, falls inside its window is acknowledged. Payments
+// acknowledged here drop it at once; those acknowledged on another replica
+// only once their event arrives, up to STREAM_PUBLISH_INTERVAL later. Pub/sub
+// does not redeliver, so if an event is lost the entry can lag behind Redis
+// until it expires: the TTL is the only hard staleness bound. Acks outside a
+// window neither drop its entry nor keep a read of it from being stored.
+type summaryCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]summaryCacheEntry
+	reads   map[*summaryRead]struct{}
+}
+
+func newSummaryCache(ttl time.Duration) *summaryCache {
+	return &summaryCache{
+		ttl:     ttl,
+		entries: make(map[string]summaryCacheEntry),
+		reads:   make(map[*summaryRead]struct{}),
+	}
+}
+
+func summaryCacheKey(filters dtos.GetPaymentsSummaryFilters, opts dtos.SummaryOptions) string {
+	format := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+
+	return fmt.Sprintf("%s|%s|%s|%t|%t|%s|%s|%t|%t|%t",
+		filters.MerchantId, format(filters.From), format(filters.To), filters.FromExclusive, filters.ToExclusive,
+		filters.TimeField, opts.GroupBy, opts.Fees, opts.Latency, opts.Currencies)
+}
+
+// get returns the cached summary, or on a miss starts a read of the filters'
+// window that must be finished with put or abandon.
+func (sc *summaryCache) get(key string, filters dtos.GetPaymentsSummaryFilters) (*entities.PaymentsSummary, *summaryRead, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	entry, ok := sc.entries[key]
+	if ok && time.Now().Before(entry.expires) {
+		summaryCacheHits.Inc()
+		return entry.summary, nil, true
+	}
+
+	delete(sc.entries, key)
+	summaryCacheMisses.Inc()
+
+	read := &summaryRead{window: summaryWindow{from: filters.From, to: filters.To, timeField: filters.TimeField}}
+	sc.reads[read] = struct{}{}
+	return nil, read, false
+}
+
+// put stores the summary a read produced unless the read went stale.
+func (sc *summaryCache) put(key string, read *summaryRead, summary *entities.PaymentsSummary) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	delete(sc.reads, read)
+	if read.stale || sc.ttl <= 0 {
+		return
+	}
+
+	sc.entries[key] = summaryCacheEntry{
+		summary: summary,
+		window:  read.window,
+		expires: time.Now().Add(sc.ttl),
+	}
+}
+
+func (sc *summaryCache) abandon(read *summaryRead) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	delete(sc.reads, read)
+}
+
+func (sc *summaryCache) reset() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	clear(sc.entries)
+	for read := range sc.reads {
+		read.stale = true
+	}
+}
+
+func (sc *summaryCache) invalidate(requestedAt, submittedAt time.Time) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for key, entry := range sc.entries {
+		if entry.window.covers(requestedAt, submittedAt) {
+			delete(sc.entries, key)
+		}
+	}
+	for read := range sc.reads {
+		if read.window.covers(requestedAt, submittedAt) {
+			read.stale = true
+		}
+	}
+}
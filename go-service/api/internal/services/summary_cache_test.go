@@ -0,0 +1,53 @@
+package services
+
+import (
+	"go-service/internal/dtos"
+	"go-service/internal/entities"
+	"testing"
+	"time"
+)
+
+func TestSummaryCacheIgnoresAcksOutsideTheWindow(t *testing.T) {
+	sc := newSummaryCache(time.Minute)
+	from := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	filters := dtos.GetPaymentsSummaryFilters{From: from, To: from.Add(time.Minute)}
+	key := summaryCacheKey(filters, dtos.SummaryOptions{})
+
+	_, read, ok := sc.get(key, filters)
+	if ok {
+		t.Fatal("empty cache reported a hit")
+	}
+	sc.invalidate(from.Add(time.Hour), time.Time{})
+	sc.put(key, read, &entities.PaymentsSummary{})
+
+	if _, _, ok := sc.get(key, filters); !ok {
+		t.Fatal("an ack outside the window kept the read from being cached")
+	}
+
+	sc.invalidate(from.Add(2*time.Hour), time.Time{})
+	if _, _, ok := sc.get(key, filters); !ok {
+		t.Fatal("an ack outside the window dropped the entry")
+	}
+}
+
+func TestSummaryCacheDropsReadsRacingAnAckInTheWindow(t *testing.T) {
+	sc := newSummaryCache(time.Minute)
+	from := time.Date(2025, 7, 15, 12, 0, 0, 0, time.UTC)
+	filters := dtos.GetPaymentsSummaryFilters{From: from, To: from.Add(time.Minute)}
+	key := summaryCacheKey(filters, dtos.SummaryOptions{})
+
+	_, read, _ := sc.get(key, filters)
+	sc.invalidate(from.Add(time.Second), time.Time{})
+	sc.put(key, read, &entities.PaymentsSummary{})
+
+	_, read, ok := sc.get(key, filters)
+	if ok {
+		t.Fatal("a read that raced an ack in its window was cached")
+	}
+	sc.put(key, read, &entities.PaymentsSummary{})
+
+	sc.invalidate(from.Add(time.Second), time.Time{})
+	if _, _, ok := sc.get(key, filters); ok {
+		t.Fatal("an ack in the window left the entry cached")
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"go-service/internal/config"
+	"go-service/internal/queue"
+	"log/slog"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+type WorkerPool struct {
+	cfg     config.WorkerPoolConfig
+	ps      *PaymentService
+	q       queue.PaymentQueueInterface
+	pm      ProcessorManagerInterface
+	workers []chan struct{}
+	nextID  int
+
+	// live counts worker goroutines, including retired ones still finishing
+	// their batch, since each may hold a Redis connection in a blocking dequeue.
+	live atomic.Int64
+
+	succeeded atomic.Int64
+	failed    atomic.Int64
+}
+
+func NewWorkerPool(cfg config.WorkerPoolConfig, ps *PaymentService) *WorkerPool {
+	return &WorkerPool{
+		cfg: cfg,
+		ps:  ps,
+		q:   ps.q,
+		pm:  ps.pm,
+	}
+}
+
+func (wp *WorkerPool) Size() int {
+	return len(wp.workers)
+}
+
+func (wp *WorkerPool) Run(ctx context.Context) {
+	wp.resize(ctx, wp.cfg.MinWorkers)
+
+	ticker := time.NewTicker(wp.cfg.ScaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			wp.resize(ctx, 0)
+			return
+		case <-ticker.C:
+			target := wp.target(ctx)
+			if target != len(wp.workers) {
+				slog.Debug("resizing worker pool", "from", len(wp.workers), "to", target)
+				wp.resize(ctx, target)
+			}
+		}
+	}
+}
+
+func (wp *WorkerPool) record(succeeded, failed int) {
+	wp.succeeded.Add(int64(succeeded))
+	wp.failed.Add(int64(failed))
+}
+
+func (wp *WorkerPool) target(ctx context.Context) int {
+	current := len(wp.workers)
+
+	succeeded := wp.succeeded.Swap(0)
+	failed := wp.failed.Swap(0)
+	if total := succeeded + failed; total > 0 && float64(failed)/float64(total) >= wp.cfg.FailureRatio {
+		return max(wp.cfg.MinWorkers, current/2)
+	}
+
+	if wp.allProcessorsFailing() {
+		return wp.cfg.MinWorkers
+	}
+
+	depth, err := wp.q.Len(ctx)
+	if err != nil {
+		slog.Error("failed to read queue depth", "error", err)
+		return current
+	}
+
+	// Each worker settles a batch per processor round-trip, so the pool needs
+	// depth*latency/batch worker-seconds to drain the backlog within DrainTarget.
+	latency := wp.latency()
+	needed := float64(depth) * latency.Seconds() / (float64(config.DequeueBatchSize) * wp.cfg.DrainTarget.Seconds())
+	target := min(max(int(math.Ceil(needed)), wp.cfg.MinWorkers), wp.cfg.MaxWorkers)
+
+	if target < current {
+		return current - max(1, (current-target)/2)
+	}
+	return target
+}
+
+func (wp *WorkerPool) latency() time.Duration {
+	latency, ok := wp.ps.latency.Percentile(0.95)
+	if !ok {
+		latency = wp.cfg.InitialLatency
+	}
+
+	if status, ok := wp.pm.Status(config.DefaultProcessor); ok && !status.Failing {
+		latency = max(latency, time.Duration(status.MinResponseTime)*time.Millisecond)
+	}
+
+	return latency
+}
+
+func (wp *WorkerPool) allProcessorsFailing() bool {
+	for _, name := range []string{config.DefaultProcessor, config.FallbackProcessor} {
+		status, ok := wp.pm.Status(name)
+		if !ok || !status.Failing {
+			return false
+		}
+	}
+	return true
+}
+
+// resize retires workers by asking them to stop before their next dequeue
+// rather than by cancelling their context, so a batch already handed to the
+// processors is never aborted half way. The pool never grows past MaxWorkers
+// live goroutines; retired workers leave within DequeueTimeout plus one
+// dispatch, and the next tick makes up the difference.
+func (wp *WorkerPool) resize(ctx context.Context, size int) {
+	for len(wp.workers) < size && wp.live.Load() < int64(wp.cfg.MaxWorkers) {
+		retire := make(chan struct{})
+		wp.workers = append(wp.workers, retire)
+		wp.live.Add(1)
+		go func(id int) {
+			defer wp.live.Add(-1)
+			wp.ps.worker(ctx, retire, id)
+		}(wp.nextID)
+		wp.nextID++
+	}
+
+	for len(wp.workers) > size {
+		last := len(wp.workers) - 1
+		close(wp.workers[last])
+		wp.workers = wp.workers[:last]
+	}
+}
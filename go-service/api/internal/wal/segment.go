@@ -0,0 +1,127 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-service/internal/dtos"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Each record is its payload length and CRC-32C, both little endian uint32,
+// followed by the payment as JSON.
+const (
+	recordHeaderSize = 8
+	maxRecordSize    = 1 << 20
+
+	segmentPrefix = "wal-"
+	segmentSuffix = ".log"
+)
+
+// ErrTornRecord marks a record that was cut short or does not match its
+// checksum, usually the last one written before a crash.
+var ErrTornRecord = errors.New("torn or corrupt record")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func appendRecord(w io.Writer, payload []byte) (int, error) {
+	var header [recordHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.Checksum(payload, crcTable))
+
+	if _, err := w.Write(header[:]); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return 0, err
+	}
+	return recordHeaderSize + len(payload), nil
+}
+
+func segmentName(seq uint64) string {
+	return fmt.Sprintf("%s%016d%s", segmentPrefix, seq, segmentSuffix)
+}
+
+func segmentSeq(path string) (uint64, bool) {
+	name := filepath.Base(path)
+	if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), 10, 64)
+	return seq, err == nil
+}
+
+// Segments lists the segment files in dir, oldest first.
+func Segments(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if _, ok := segmentSeq(entry.Name()); ok && !entry.IsDir() {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// ReadSegment returns every intact record of the segment at path. When it
+// meets a torn record it stops there and returns what it read so far along
+// with an error wrapping ErrTornRecord.
+func ReadSegment(path string) ([]*dtos.Payment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var payments []*dtos.Payment
+	var offset int64
+
+	for {
+		var header [recordHeaderSize]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return payments, nil
+			}
+			return payments, tornRecord(path, offset, err)
+		}
+
+		size := binary.LittleEndian.Uint32(header[0:4])
+		if size > maxRecordSize {
+			return payments, tornRecord(path, offset, fmt.Errorf("record of %d bytes", size))
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return payments, tornRecord(path, offset, err)
+		}
+		if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+			return payments, tornRecord(path, offset, errors.New("checksum mismatch"))
+		}
+
+		var payment dtos.Payment
+		if err := json.Unmarshal(payload, &payment); err != nil {
+			return payments, tornRecord(path, offset, err)
+		}
+
+		payments = append(payments, &payment)
+		offset += recordHeaderSize + int64(size)
+	}
+}
+
+func tornRecord(path string, offset int64, cause error) error {
+	return fmt.Errorf("%s at offset %d: %w: %v", path, offset, ErrTornRecord, cause)
+}
@@ -0,0 +1,285 @@
+package wal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-service/internal/config"
+	"go-service/internal/dtos"
+	"go-service/internal/metrics"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	appends       = metrics.NewCounter("wal_appends_total", "Payments written to the local write-ahead log.")
+	replayed      = metrics.NewCounter("wal_replayed_total", "Payments replayed from the write-ahead log into the queue.")
+	segmentsGauge = metrics.NewGauge("wal_segments", "Write-ahead log segments on disk, including the one being written.")
+)
+
+var ErrClosed = errors.New("write-ahead log is closed")
+
+// Log appends payments to numbered segment files. Appends only return once
+// their record is fsynced, and every append waiting at that moment shares
+// the same fsync.
+type Log struct {
+	cfg config.WALConfig
+
+	mu     sync.Mutex
+	file   *os.File
+	w      *bufio.Writer
+	seq    uint64
+	size   int64
+	batch  *syncBatch
+	closed bool
+
+	syncs chan struct{}
+	done  chan struct{}
+}
+
+type syncBatch struct {
+	done chan struct{}
+	err  error
+}
+
+func newSyncBatch() *syncBatch {
+	return &syncBatch{done: make(chan struct{})}
+}
+
+// Open starts a fresh segment after any left in cfg.Dir by an earlier run.
+// Those are replayed like the rest by Run.
+func Open(cfg config.WALConfig) (*Log, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating write-ahead log directory: %w", err)
+	}
+
+	segments, err := Segments(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var seq uint64
+	if len(segments) > 0 {
+		seq, _ = segmentSeq(segments[len(segments)-1])
+	}
+
+	l := &Log{
+		cfg:   cfg,
+		batch: newSyncBatch(),
+		syncs: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	if err := l.openSegment(seq + 1); err != nil {
+		return nil, err
+	}
+	segmentsGauge.Set(float64(len(segments) + 1))
+
+	go l.syncLoop()
+
+	return l, nil
+}
+
+func (l *Log) openSegment(seq uint64) error {
+	file, err := os.OpenFile(filepath.Join(l.cfg.Dir, segmentName(seq)), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening write-ahead log segment: %w", err)
+	}
+
+	l.file = file
+	l.w = bufio.NewWriterSize(file, 64<<10)
+	l.seq = seq
+	l.size = 0
+	return nil
+}
+
+// Append returns once every payment given is durable. The payments share
+// one fsync, along with any other append waiting at that moment.
+func (l *Log) Append(payments ...*dtos.Payment) error {
+	payloads := make([][]byte, len(payments))
+	for i, p := range payments {
+		payload, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		payloads[i] = payload
+	}
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return ErrClosed
+	}
+	for _, payload := range payloads {
+		n, err := appendRecord(l.w, payload)
+		if err != nil {
+			l.mu.Unlock()
+			return err
+		}
+		l.size += int64(n)
+	}
+	batch := l.batch
+	select {
+	case l.syncs <- struct{}{}:
+	default:
+	}
+	l.mu.Unlock()
+
+	<-batch.done
+	if batch.err == nil {
+		appends.Add(int64(len(payments)))
+	}
+	return batch.err
+}
+
+// syncLoop waits SyncInterval after the first append of a batch so appends
+// arriving meanwhile are made durable by the same fsync.
+func (l *Log) syncLoop() {
+	defer close(l.done)
+	for range l.syncs {
+		time.Sleep(l.cfg.SyncInterval)
+
+		l.mu.Lock()
+		l.syncLocked()
+		if l.cfg.SegmentBytes > 0 && l.size >= l.cfg.SegmentBytes {
+			if err := l.rotateLocked(); err != nil {
+				slog.Error("failed to rotate write-ahead log segment", "error", err)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (l *Log) syncLocked() {
+	batch := l.batch
+	l.batch = newSyncBatch()
+
+	err := l.w.Flush()
+	if err == nil {
+		err = l.file.Sync()
+	}
+
+	batch.err = err
+	close(batch.done)
+}
+
+func (l *Log) rotateLocked() error {
+	l.syncLocked()
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	if err := l.openSegment(l.seq + 1); err != nil {
+		return err
+	}
+	segmentsGauge.Add(1)
+	return nil
+}
+
+// Replay hands every sealed segment to fn in order, at most ReplayBatchSize
+// payments at a time, and deletes a segment once fn accepted all of it. The
+// segment being written is sealed first if it holds anything. A segment is
+// replayed again from the start if fn fails part way, so fn must ignore
+// payments it already has.
+func (l *Log) Replay(fn func([]*dtos.Payment) error) error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return ErrClosed
+	}
+	if l.size > 0 {
+		if err := l.rotateLocked(); err != nil {
+			l.mu.Unlock()
+			return err
+		}
+	}
+	active := l.file.Name()
+	l.mu.Unlock()
+
+	segments, err := Segments(l.cfg.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range segments {
+		if path == active {
+			continue
+		}
+
+		payments, err := ReadSegment(path)
+		if errors.Is(err, ErrTornRecord) {
+			slog.Warn("write-ahead log segment ends in a torn record, replaying the records before it", "error", err)
+		} else if err != nil {
+			return err
+		}
+
+		for chunk := range slices.Chunk(payments, l.cfg.ReplayBatchSize) {
+			if err := fn(chunk); err != nil {
+				return err
+			}
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		replayed.Add(int64(len(payments)))
+		segmentsGauge.Add(-1)
+		slog.Info("replayed write-ahead log segment", "segment", filepath.Base(path), "payments", len(payments))
+	}
+
+	return nil
+}
+
+// Run replays the log every ReplayInterval until ctx is done. While Redis is
+// still unavailable replays fail and are simply tried again.
+func (l *Log) Run(ctx context.Context, fn func([]*dtos.Payment) error) {
+	ticker := time.NewTicker(l.cfg.ReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := l.Replay(fn); err != nil && !errors.Is(err, ErrClosed) {
+			slog.Warn("failed to replay write-ahead log", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close syncs pending appends and closes the current segment. Segments that
+// were not replayed stay on disk for the next run.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.syncs)
+	l.mu.Unlock()
+
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.syncLocked()
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+
+	// An empty segment only costs a file, so drop it instead of leaving it
+	// for the next run to replay.
+	if l.size == 0 {
+		segmentsGauge.Add(-1)
+		return os.Remove(l.file.Name())
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package webhooks
+
+import "context"
+
+type NotifierInterface interface {
+	Enabled() bool
+	Notify(ctx context.Context, notifications ...Notification) error
+	Register(ctx context.Context, merchantId, url string) error
+	Unregister(ctx context.Context, merchantId, url string) error
+	Endpoints(ctx context.Context, merchantId string) ([]string, error)
+}
@@ -0,0 +1,393 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-service/internal/config"
+	"go-service/internal/merchants"
+	"go-service/internal/metrics"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	EventPaymentProcessed    = "payment.processed"
+	EventPaymentDeadLettered = "payment.dead_lettered"
+
+	queueKey    = "webhooks:queue"
+	inflightKey = "webhooks:inflight"
+	failedKey   = "webhooks:failed"
+)
+
+var (
+	ErrInvalidCallbackUrl = errors.New("callback url must be an absolute http or https url to a public host")
+	ErrBlockedAddress     = errors.New("webhook receiver resolves to a loopback, private or link-local address")
+	ErrDisabled           = errors.New("webhooks are disabled because WEBHOOK_SECRET is not set")
+)
+
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+var (
+	deliveriesSucceeded = metrics.NewCounter("webhook_deliveries_total", "Webhook delivery attempts by outcome.", "result", "success")
+	deliveriesRetried   = metrics.NewCounter("webhook_deliveries_total", "Webhook delivery attempts by outcome.", "result", "retry")
+	deliveriesFailed    = metrics.NewCounter("webhook_deliveries_total", "Webhook delivery attempts by outcome.", "result", "failed")
+	deliveriesPending   = metrics.NewGauge("webhook_deliveries_pending", "Webhook deliveries waiting in the retry queue.")
+	deliveriesInFlight  = metrics.NewGauge("webhook_deliveries_in_flight", "Webhook deliveries currently leased by a dispatcher.")
+)
+
+// claimScript moves due deliveries from the queue into the in-flight set with
+// a lease, so a replica that dies mid-delivery does not lose them.
+var claimScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, member in ipairs(due) do
+	redis.call('ZREM', KEYS[1], member)
+	redis.call('ZADD', KEYS[2], ARGV[3], member)
+end
+return due
+`)
+
+var reapScript = redis.NewScript(`
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+for _, member in ipairs(expired) do
+	redis.call('ZREM', KEYS[2], member)
+	redis.call('ZADD', KEYS[1], ARGV[1], member)
+end
+return #expired
+`)
+
+type Event struct {
+	Type          string  `json:"type"`
+	CorrelationId string  `json:"correlationId"`
+	Amount        float64 `json:"amount"`
+	Currency      string  `json:"currency"`
+	RequestedAt   string  `json:"requestedAt"`
+	Processor     string  `json:"processor,omitempty"`
+	SubmittedAt   string  `json:"submittedAt,omitempty"`
+	ProcessedAt   string  `json:"processedAt,omitempty"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// Notification is an event for the webhooks its merchant registered, plus the
+// payment's own callback url when it has one.
+type Notification struct {
+	MerchantId  string
+	CallbackUrl string
+	Event       Event
+}
+
+type delivery struct {
+	Id      string `json:"id"`
+	Url     string `json:"url"`
+	Attempt int    `json:"attempt"`
+	Event   Event  `json:"event"`
+}
+
+type Notifier struct {
+	cfg    config.WebhookConfig
+	rc     *redis.Client
+	client *http.Client
+}
+
+// NewNotifier returns a notifier that refuses to deliver to hosts inside the
+// deployment, checking the address actually dialed so redirects and DNS
+// changes after registration cannot reach them either.
+func NewNotifier(cfg config.WebhookConfig, rc *redis.Client) *Notifier {
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil || blockedAddr(ip) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &Notifier{
+		cfg: cfg,
+		rc:  rc,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: transport,
+		},
+	}
+}
+
+// Enabled reports whether a signing secret is configured. Without one
+// receivers could not tell deliveries from forgeries, so nothing is sent.
+func (n *Notifier) Enabled() bool {
+	return n.cfg.Secret != ""
+}
+
+// ValidateUrl accepts absolute http(s) urls whose host is neither an internal
+// name, such as a single-label service name, nor a loopback, private,
+// link-local or unspecified address.
+func ValidateUrl(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidCallbackUrl
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if blockedAddr(ip) {
+			return ErrInvalidCallbackUrl
+		}
+		return nil
+	}
+
+	if !strings.Contains(host, ".") || host == "localhost" ||
+		strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".local") || strings.HasSuffix(host, ".internal") {
+		return ErrInvalidCallbackUrl
+	}
+	return nil
+}
+
+func blockedAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+func endpointsKey(merchantId string) string {
+	return merchants.KeyPrefix(merchantId) + "webhooks:endpoints"
+}
+
+func (n *Notifier) Register(ctx context.Context, merchantId, url string) error {
+	if !n.Enabled() {
+		return ErrDisabled
+	}
+	if err := ValidateUrl(url); err != nil {
+		return err
+	}
+	return n.rc.SAdd(ctx, endpointsKey(merchantId), url).Err()
+}
+
+func (n *Notifier) Unregister(ctx context.Context, merchantId, url string) error {
+	if !n.Enabled() {
+		return ErrDisabled
+	}
+	return n.rc.SRem(ctx, endpointsKey(merchantId), url).Err()
+}
+
+func (n *Notifier) Endpoints(ctx context.Context, merchantId string) ([]string, error) {
+	if !n.Enabled() {
+		return nil, ErrDisabled
+	}
+	return n.rc.SMembers(ctx, endpointsKey(merchantId)).Result()
+}
+
+// Notify queues a delivery per notification and receiving url. Endpoints are
+// read with one round trip for all the merchants involved and the deliveries
+// are queued with another.
+func (n *Notifier) Notify(ctx context.Context, notifications ...Notification) error {
+	if !n.Enabled() || len(notifications) == 0 {
+		return nil
+	}
+
+	endpoints := make(map[string]*redis.StringSliceCmd)
+	pipe := n.rc.Pipeline()
+	for _, notification := range notifications {
+		if _, ok := endpoints[notification.MerchantId]; !ok {
+			endpoints[notification.MerchantId] = pipe.SMembers(ctx, endpointsKey(notification.MerchantId))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("listing webhook endpoints: %w", err)
+	}
+
+	now := float64(time.Now().UnixMilli())
+	var members []*redis.Z
+	for _, notification := range notifications {
+		urls := endpoints[notification.MerchantId].Val()
+		if notification.CallbackUrl != "" {
+			urls = append(urls, notification.CallbackUrl)
+		}
+
+		for _, u := range urls {
+			payload, err := json.Marshal(delivery{
+				Id:    newDeliveryId(),
+				Url:   u,
+				Event: notification.Event,
+			})
+			if err != nil {
+				return err
+			}
+			members = append(members, &redis.Z{Score: now, Member: payload})
+		}
+	}
+
+	if len(members) == 0 {
+		return nil
+	}
+	return n.rc.ZAdd(ctx, queueKey, members...).Err()
+}
+
+func (n *Notifier) Run(ctx context.Context) {
+	if !n.Enabled() {
+		slog.Warn("webhooks are disabled, set WEBHOOK_SECRET to enable them")
+		return
+	}
+
+	ticker := time.NewTicker(n.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := n.dispatchDue(ctx); err != nil && ctx.Err() == nil {
+				slog.Error("failed to dispatch webhooks", "error", err)
+			}
+		}
+	}
+}
+
+func (n *Notifier) dispatchDue(ctx context.Context) error {
+	now := time.Now()
+	keys := []string{queueKey, inflightKey}
+
+	if err := reapScript.Run(ctx, n.rc, keys, now.UnixMilli()).Err(); err != nil {
+		return fmt.Errorf("reaping expired webhook leases: %w", err)
+	}
+
+	claimed, err := claimScript.Run(ctx, n.rc, keys, now.UnixMilli(), n.cfg.BatchSize, now.Add(n.cfg.Lease).UnixMilli()).StringSlice()
+	if err != nil {
+		return fmt.Errorf("claiming webhook deliveries: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, member := range claimed {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n.deliver(ctx, member)
+		}()
+	}
+	wg.Wait()
+
+	pending, _ := n.rc.ZCard(ctx, queueKey).Result()
+	inflight, _ := n.rc.ZCard(ctx, inflightKey).Result()
+	deliveriesPending.Set(float64(pending))
+	deliveriesInFlight.Set(float64(inflight))
+
+	return nil
+}
+
+func (n *Notifier) deliver(ctx context.Context, member string) {
+	var d delivery
+	if err := json.Unmarshal([]byte(member), &d); err != nil {
+		slog.Error("dropping malformed webhook delivery", "error", err)
+		n.rc.ZRem(ctx, inflightKey, member)
+		return
+	}
+
+	err := n.post(ctx, d)
+
+	pipe := n.rc.TxPipeline()
+	pipe.ZRem(ctx, inflightKey, member)
+
+	switch {
+	case err == nil:
+		deliveriesSucceeded.Inc()
+	case d.Attempt+1 >= n.cfg.MaxAttempts:
+		deliveriesFailed.Inc()
+		slog.Warn("giving up on webhook delivery", "url", d.Url, "correlationId", d.Event.CorrelationId, "error", err)
+		d.Attempt++
+		payload, _ := json.Marshal(d)
+		pipe.ZAdd(ctx, failedKey, &redis.Z{Score: float64(time.Now().UnixMilli()), Member: payload})
+	default:
+		deliveriesRetried.Inc()
+		d.Attempt++
+		payload, _ := json.Marshal(d)
+		next := time.Now().Add(n.backoff(d.Attempt))
+		pipe.ZAdd(ctx, queueKey, &redis.Z{Score: float64(next.UnixMilli()), Member: payload})
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Error("failed to record webhook delivery outcome", "url", d.Url, "error", err)
+	}
+}
+
+func (n *Notifier) backoff(attempt int) time.Duration {
+	delay := n.cfg.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > n.cfg.MaxDelay {
+		return n.cfg.MaxDelay
+	}
+	return delay
+}
+
+func (n *Notifier) post(ctx context.Context, d delivery) error {
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", d.Id)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(n.cfg.Secret, timestamp, body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook receiver returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>", which receivers
+// recompute with the shared secret to authenticate a delivery.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newDeliveryId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,170 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-service/internal/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestValidateUrl(t *testing.T) {
+	valid := []string{
+		"https://example.com/hooks",
+		"http://hooks.example.com:8080/payments?x=1",
+		"https://93.184.216.34/hook",
+	}
+	for _, raw := range valid {
+		if err := ValidateUrl(raw); err != nil {
+			t.Errorf("ValidateUrl(%q) = %v, want nil", raw, err)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"/relative",
+		"ftp://example.com/file",
+		"http://",
+		"http://localhost:9999/hook",
+		"http://api.localhost/hook",
+		"http://redis:6379",
+		"http://payment-processor-default:8080/payments",
+		"http://metadata.google.internal/computeMetadata",
+		"http://printer.local/",
+		"http://127.0.0.1/hook",
+		"http://10.0.0.5/hook",
+		"http://172.16.3.4/hook",
+		"http://192.168.1.10/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://100.64.0.1/hook",
+		"http://0.0.0.0/hook",
+		"http://[::1]/hook",
+		"http://[fe80::1]/hook",
+		"http://[fd00::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+	}
+	for _, raw := range invalid {
+		if err := ValidateUrl(raw); !errors.Is(err, ErrInvalidCallbackUrl) {
+			t.Errorf("ValidateUrl(%q) = %v, want ErrInvalidCallbackUrl", raw, err)
+		}
+	}
+}
+
+func TestPostSignsDelivery(t *testing.T) {
+	const secret = "test-secret"
+
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan received, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{header: r.Header.Clone(), body: body}
+	}))
+	defer receiver.Close()
+
+	// The stand-in receiver listens on loopback, which NewNotifier refuses to
+	// dial, so it is reached with the test server's own client.
+	n := &Notifier{cfg: config.WebhookConfig{Secret: secret}, client: receiver.Client()}
+	d := delivery{
+		Id:  "delivery-1",
+		Url: receiver.URL,
+		Event: Event{
+			Type:          EventPaymentProcessed,
+			CorrelationId: "4a7901b8-7d26-4d9d-aa19-4dc1c7cf60b3",
+			Amount:        19.9,
+			Currency:      "BRL",
+			Processor:     "default",
+		},
+	}
+
+	if err := n.post(context.Background(), d); err != nil {
+		t.Fatalf("post: %v", err)
+	}
+
+	got := <-requests
+	if got.header.Get("Content-Type") != "application/json" {
+		t.Errorf("Content-Type = %q", got.header.Get("Content-Type"))
+	}
+	if got.header.Get("X-Webhook-Id") != d.Id {
+		t.Errorf("X-Webhook-Id = %q, want %q", got.header.Get("X-Webhook-Id"), d.Id)
+	}
+
+	timestamp := got.header.Get("X-Webhook-Timestamp")
+	if want := "sha256=" + Sign(secret, timestamp, got.body); got.header.Get("X-Webhook-Signature") != want {
+		t.Errorf("X-Webhook-Signature = %q, want %q", got.header.Get("X-Webhook-Signature"), want)
+	}
+	if forged := "sha256=" + Sign("", timestamp, got.body); got.header.Get("X-Webhook-Signature") == forged {
+		t.Error("signature matches one made without the secret")
+	}
+
+	var event Event
+	if err := json.Unmarshal(got.body, &event); err != nil {
+		t.Fatalf("decoding delivered event: %v", err)
+	}
+	if event != d.Event {
+		t.Errorf("delivered event = %+v, want %+v", event, d.Event)
+	}
+}
+
+func TestPostFailsOnErrorStatus(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer receiver.Close()
+
+	n := &Notifier{cfg: config.WebhookConfig{Secret: "s"}, client: receiver.Client()}
+	if err := n.post(context.Background(), delivery{Id: "d", Url: receiver.URL}); err == nil {
+		t.Fatal("post succeeded against a receiver answering 503")
+	}
+}
+
+func TestNotifierRefusesToDialLoopback(t *testing.T) {
+	var called atomic.Bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called.Store(true)
+	}))
+	defer receiver.Close()
+
+	n := NewNotifier(config.WebhookConfig{Secret: "s", Timeout: time.Second}, nil)
+	err := n.post(context.Background(), delivery{Id: "d", Url: receiver.URL})
+	if !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("post = %v, want ErrBlockedAddress", err)
+	}
+	if called.Load() {
+		t.Fatal("loopback receiver was reached")
+	}
+}
+
+func TestNotifierDisabledWithoutSecret(t *testing.T) {
+	n := NewNotifier(config.WebhookConfig{Timeout: time.Second}, nil)
+	ctx := context.Background()
+
+	if n.Enabled() {
+		t.Fatal("notifier without a secret reports itself enabled")
+	}
+	if err := n.Register(ctx, "m1", "https://example.com/hook"); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Register = %v, want ErrDisabled", err)
+	}
+	if _, err := n.Endpoints(ctx, "m1"); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Endpoints = %v, want ErrDisabled", err)
+	}
+	if err := n.Notify(ctx, Notification{MerchantId: "m1", CallbackUrl: "https://example.com/hook"}); err != nil {
+		t.Errorf("Notify = %v, want nil", err)
+	}
+}
+
+func TestEndpointsAreScopedPerMerchant(t *testing.T) {
+	if endpointsKey("m1") == endpointsKey("m2") {
+		t.Fatal("merchants share a webhook endpoint set")
+	}
+	if endpointsKey("m1") == endpointsKey("default") {
+		t.Fatal("a merchant shares the default merchant's webhook endpoint set")
+	}
+}